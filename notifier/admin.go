@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// adminToken authenticates the admin API (empty disables it)
+var adminToken = ""
+
+// registerAdminRoutes adds the admin API to mux. Every route requires
+// "Authorization: Bearer <ADMIN_TOKEN>".
+func registerAdminRoutes(mux *http.ServeMux) {
+	mux.Handle("GET /admin/users", requireAdmin(handleAdminUsers))
+	mux.Handle("GET /admin/notifications", requireAdmin(handleAdminNotifications))
+	mux.Handle("GET /admin/notifications/{user}/{id}", requireAdmin(handleAdminNotification))
+	mux.Handle("POST /admin/notifications/{user}/{id}/run", requireAdmin(handleAdminRunNotification))
+	mux.Handle("POST /admin/notifications/{user}/{id}/reset-seen", requireAdmin(handleAdminResetSeen))
+	mux.Handle("GET /admin/users/{user}/history", requireAdmin(handleAdminHistory))
+}
+
+func requireAdmin(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next(w, r)
+	})
+}
+
+// adminNotification is a notification as parsed from discord_notifications,
+// with its schedule and last run
+type adminNotification struct {
+	UserID       string           `json:"user_id"`
+	Notification Notification     `json:"notification"`
+	NextRunAt    *time.Time       `json:"next_run_at,omitempty"`
+	Interval     string           `json:"interval,omitempty"`
+	NewItemsRate float64          `json:"new_items_rate"`
+	LastRun      *notificationRun `json:"last_run,omitempty"`
+}
+
+type adminUser struct {
+	AuthUserID    string              `json:"auth_user_id"`
+	Username      string              `json:"username"`
+	Email         string              `json:"email"`
+	Tier          string              `json:"tier,omitempty"`
+	HasWebhook    bool                `json:"has_webhook"`
+	Notifications []adminNotification `json:"notifications"`
+}
+
+func newAdminNotification(user User, notif Notification) adminNotification {
+	n := adminNotification{UserID: user.AuthUserID, Notification: notif}
+	if pollScheduler != nil {
+		if entry, ok := pollScheduler.Entry(user.AuthUserID, notif.ID); ok {
+			nextRun := entry.NextRun
+			n.NextRunAt = &nextRun
+			n.Interval = entry.Interval.String()
+			n.NewItemsRate = entry.NewItemsRate
+		}
+	}
+	if run, ok := notificationRuns.Get(user.AuthUserID, notif.ID); ok {
+		n.LastRun = &run
+	}
+	return n
+}
+
+func handleAdminUsers(w http.ResponseWriter, r *http.Request) {
+	users := currentSubscribers()
+	result := make([]adminUser, 0, len(users))
+	for _, user := range users {
+		u := adminUser{
+			AuthUserID:    user.AuthUserID,
+			Username:      user.Username,
+			Email:         user.Email,
+			Tier:          user.Tier,
+			HasWebhook:    user.DiscordWebhookURL != "",
+			Notifications: make([]adminNotification, 0, len(user.Notifications)),
+		}
+		for _, notif := range user.Notifications {
+			u.Notifications = append(u.Notifications, newAdminNotification(user, notif))
+		}
+		result = append(result, u)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func handleAdminNotifications(w http.ResponseWriter, r *http.Request) {
+	result := make([]adminNotification, 0)
+	for _, user := range currentSubscribers() {
+		for _, notif := range user.Notifications {
+			result = append(result, newAdminNotification(user, notif))
+		}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func handleAdminNotification(w http.ResponseWriter, r *http.Request) {
+	user, notif, ok := findNotification(r.PathValue("user"), r.PathValue("id"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "notification not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, newAdminNotification(user, notif))
+}
+
+// handleAdminRunNotification checks a single notification immediately,
+// delivering any new items exactly as a scheduled cycle would
+func handleAdminRunNotification(w http.ResponseWriter, r *http.Request) {
+	user, notif, ok := findNotification(r.PathValue("user"), r.PathValue("id"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "notification not found")
+		return
+	}
+
+	// Only the instance holding the user's shard may deliver, or alerts are sent twice
+	if !ownsUser(user.AuthUserID) {
+		writeJSONError(w, http.StatusConflict, "another instance checks this user; use the leader or the instance holding their shard")
+		return
+	}
+	// Running alongside a cycle could deliver the same items twice
+	if !startProcessing() {
+		writeJSONError(w, http.StatusConflict, "a notification cycle is running, try again shortly")
+		return
+	}
+	defer finishProcessing()
+
+	logger := slog.With("admin_action", "run", "user_id", user.AuthUserID, "notification_id", notif.ID)
+	logger.Info("force-running notification")
+
+	// Detach from the request so a client disconnect doesn't abort deliveries midway
+	ctx := withLogger(context.WithoutCancel(r.Context()), logger)
+	single := user
+	single.Notifications = []Notification{notif}
+	if pollScheduler != nil {
+		if err := loadSeenHistory(ctx, pollScheduler.Unchecked([]User{single})); err != nil {
+			writeJSONError(w, http.StatusServiceUnavailable, "failed to load notification history: "+err.Error())
+			return
+		}
+	}
+	newItems := processUserNotifications(ctx, single)
+
+	if pollScheduler != nil {
+		if count, ok := newItems[pollKey(user.AuthUserID, notif.ID)]; ok {
+			pollScheduler.Record(user, notif.ID, count, time.Now())
+		} else {
+			pollScheduler.Failed(user, notif.ID, time.Now())
+		}
+	}
+	saveRunStatuses(ctx)
+	writeJSON(w, http.StatusOK, newAdminNotification(user, notif))
+}
+
+func handleAdminResetSeen(w http.ResponseWriter, r *http.Request) {
+	user, notif, ok := findNotification(r.PathValue("user"), r.PathValue("id"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "notification not found")
+		return
+	}
+
+	removed := resetSeenItems(notif.ID)
+	slog.Info("reset seen items", "admin_action", "reset-seen", "user_id", user.AuthUserID, "notification_id", notif.ID, "removed", removed)
+	writeJSON(w, http.StatusOK, map[string]int{"removed": removed})
+}
+
+// handleAdminHistory returns a user's most recently delivered items from
+// notification_history, optionally for one notification (?notification=<id>)
+func handleAdminHistory(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			writeJSONError(w, http.StatusBadRequest, "limit must be between 1 and 1000")
+			return
+		}
+		limit = n
+	}
+
+	query := db.From("notification_history").
+		Eq("auth_user_id", r.PathValue("user")).
+		Order("delivered_at", false).
+		Limit(limit)
+	if notificationID := r.URL.Query().Get("notification"); notificationID != "" {
+		query = query.Eq("notification_id", notificationID)
+	}
+
+	entries := []historyEntry{}
+	if _, err := query.Execute(r.Context(), &entries); err != nil {
+		slog.Error("failed to read notification history", "user_id", r.PathValue("user"), "error", err)
+		writeJSONError(w, http.StatusBadGateway, "failed to read notification history")
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// findNotification looks up a notification among the current subscribers
+func findNotification(userID, notificationID string) (User, Notification, bool) {
+	for _, user := range currentSubscribers() {
+		if user.AuthUserID != userID {
+			continue
+		}
+		for _, notif := range user.Notifications {
+			if notif.ID == notificationID {
+				return user, notif, true
+			}
+		}
+	}
+	return User{}, Notification{}, false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminRunNotificationConflictsWithCycle(t *testing.T) {
+	previous := subscribers
+	subscribers = []User{{AuthUserID: "u1", Notifications: []Notification{{ID: "n1", SearchTerm: "pokemon"}}}}
+	t.Cleanup(func() { subscribers = previous })
+
+	if !startProcessing() {
+		t.Fatal("startProcessing() = false with no cycle running")
+	}
+	t.Cleanup(finishProcessing)
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/notifications/u1/n1/run", nil)
+	req.SetPathValue("user", "u1")
+	req.SetPathValue("id", "n1")
+	rec := httptest.NewRecorder()
+	handleAdminRunNotification(rec, req)
+
+	if rec.Code != http.StatusConflict {
+		t.Errorf("status = %d, want %d while a cycle is running", rec.Code, http.StatusConflict)
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Alert rendering settings
+var (
+	// sendicoItemURL is the Sendico proxy-buy page of a listing, with {shop}
+	// and {code} replaced (empty leaves the link out)
+	sendicoItemURL = SendicoBaseURL + "/{shop}/item/{code}"
+
+	// currencyRates converts yen to currencies other than JPY and USD, in
+	// units of the currency per yen (CURRENCY_RATES, e.g. "EUR=0.0061")
+	currencyRates = map[string]float64{}
+
+	// Listing titles are translated from Japanese for the embed title, with
+	// the original kept below it
+	titleTranslationDisabled bool
+	titleTranslations        = NewTranslationCache(5000, 7*24*time.Hour, "")
+)
+
+// defaultCurrency is used when a user hasn't picked one, or picked one
+// without a rate
+const defaultCurrency = "USD"
+
+// alertItem is a listing as rendered into a Discord embed
+type alertItem struct {
+	Shop          SendicoShop
+	Code          string
+	Title         string // English if the original title was translated
+	OriginalTitle string
+	URL           string // The listing on the market
+	ProxyURL      string // The listing on Sendico, to buy it through the proxy
+	Image         string
+	PriceYen      int
+	PriceUSD      int // Sendico's conversion
+	Labels        []string
+}
+
+// newAlertItems converts Sendico listings to alert items. Titles are left
+// untranslated; see translateTitles.
+func newAlertItems(items []SendicoItem) []alertItem {
+	alerts := make([]alertItem, 0, len(items))
+	for _, item := range items {
+		// Titles are translated one per line, so they mustn't contain any
+		title := strings.Join(strings.Fields(item.Name), " ")
+		alerts = append(alerts, alertItem{
+			Shop:          item.Shop,
+			Code:          item.Code,
+			Title:         title,
+			OriginalTitle: title,
+			URL:           item.URL,
+			ProxyURL:      proxyItemURL(item.Shop, item.Code),
+			Image:         item.Image,
+			PriceYen:      item.PriceYen,
+			PriceUSD:      item.PriceUSD,
+			Labels:        item.Labels,
+		})
+	}
+	return alerts
+}
+
+// proxyItemURL returns the Sendico page of a listing, or "" if unknown
+func proxyItemURL(shop SendicoShop, code string) string {
+	if sendicoItemURL == "" || code == "" {
+		return ""
+	}
+	return strings.NewReplacer(
+		"{shop}", url.PathEscape(string(shop)),
+		"{code}", url.PathEscape(code),
+	).Replace(sendicoItemURL)
+}
+
+// translateTitles replaces Japanese titles with English translations. Titles
+// not already cached are sent in one request, one per line; if it fails or
+// the lines don't line up, those items keep their original titles.
+func translateTitles(ctx context.Context, items []alertItem) {
+	if titleTranslationDisabled || sendicoClient == nil {
+		return
+	}
+
+	var pending []int
+	var lines []string
+	for i := range items {
+		if !hasJapanese(items[i].OriginalTitle) {
+			continue
+		}
+		if translation, ok := titleTranslations.Get(items[i].OriginalTitle); ok {
+			items[i].Title = translation
+			continue
+		}
+		pending = append(pending, i)
+		lines = append(lines, items[i].OriginalTitle)
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	ctx, span := tracer.Start(ctx, "notifier.translate_titles", trace.WithAttributes(attribute.Int("notifier.titles", len(pending))))
+	translated, err := sendicoClient.TranslateText(ctx, strings.Join(lines, "\n"), "ja", "en")
+	endSpan(span, err)
+	if err != nil {
+		loggerFrom(ctx).Warn("failed to translate listing titles", "titles", len(pending), "error", err)
+		return
+	}
+	results := strings.Split(strings.TrimSpace(translated), "\n")
+	if len(results) != len(pending) {
+		loggerFrom(ctx).Warn("title translation returned a different number of lines, keeping original titles",
+			"titles", len(pending), "lines", len(results))
+		return
+	}
+	for j, i := range pending {
+		if translation := strings.TrimSpace(results[j]); translation != "" {
+			items[i].Title = translation
+			titleTranslations.Set(items[i].OriginalTitle, translation)
+		}
+	}
+}
+
+// hasJapanese reports whether s contains kana or kanji
+func hasJapanese(s string) bool {
+	for _, r := range s {
+		if unicode.In(r, unicode.Hiragana, unicode.Katakana, unicode.Han) {
+			return true
+		}
+	}
+	return false
+}
+
+// marketStyle is how a market's listings look in Discord
+type marketStyle struct {
+	Name   string
+	Color  int
+	Domain string // The market's website, also used for its icon
+}
+
+var marketStyles = map[SendicoShop]marketStyle{
+	SendicoMercari:       {Name: "Mercari Japan", Color: 0xFF0211, Domain: "jp.mercari.com"},
+	SendicoRakuma:        {Name: "Rakuten Rakuma", Color: 0xE5006E, Domain: "fril.jp"},
+	SendicoRakuten:       {Name: "Rakuten", Color: 0xBF0000, Domain: "www.rakuten.co.jp"},
+	SendicoYahooAuctions: {Name: "Yahoo Auctions", Color: 0xFF9900, Domain: "auctions.yahoo.co.jp"},
+	SendicoYahoo:         {Name: "Yahoo PayPay Flea", Color: 0xFF0033, Domain: "paypayfleamarket.yahoo.co.jp"},
+}
+
+// getMarketNameFromShop returns the human-readable market name
+func getMarketNameFromShop(shop SendicoShop) string {
+	if style, ok := marketStyles[shop]; ok {
+		return style.Name
+	}
+	return string(shop)
+}
+
+// itemEmbed renders one listing
+func itemEmbed(notification Notification, item alertItem, currency string) DiscordEmbed {
+	style, ok := marketStyles[item.Shop]
+	if !ok {
+		style = marketStyle{Name: string(item.Shop), Color: 3447003} // Blue
+	}
+
+	// Use item title, or fallback to search term
+	title := item.Title
+	if title == "" {
+		title = notification.SearchTerm
+	}
+
+	embed := DiscordEmbed{
+		Title:     truncateText(title, 200),
+		URL:       item.URL,
+		Color:     style.Color,
+		Timestamp: time.Now().Format(time.RFC3339),
+		Footer: map[string]interface{}{
+			"text": fmt.Sprintf("MMCS • %s", notification.SearchTerm),
+		},
+	}
+	if style.Domain != "" {
+		embed.Author = map[string]string{
+			"name":     style.Name,
+			"url":      "https://" + style.Domain,
+			"icon_url": "https://www.google.com/s2/favicons?sz=64&domain=" + style.Domain,
+		}
+	} else {
+		embed.Author = map[string]string{"name": style.Name}
+	}
+	if item.OriginalTitle != "" && item.OriginalTitle != title {
+		embed.Description = truncateText(item.OriginalTitle, 300)
+	}
+
+	embed.Fields = append(embed.Fields, DiscordEmbedField{
+		Name:   "Price",
+		Value:  formatPrice(item, currency),
+		Inline: true,
+	})
+	if labels := formatLabels(item.Labels); labels != "" {
+		embed.Fields = append(embed.Fields, DiscordEmbedField{
+			Name:   "Details",
+			Value:  labels,
+			Inline: true,
+		})
+	}
+	if item.ProxyURL != "" {
+		embed.Fields = append(embed.Fields, DiscordEmbedField{
+			Name:   "Buy",
+			Value:  fmt.Sprintf("[Buy via Sendico](%s)", item.ProxyURL),
+			Inline: true,
+		})
+	}
+
+	if item.Image != "" {
+		embed.Thumbnail = map[string]string{"url": item.Image}
+	}
+	return embed
+}
+
+// formatPrice shows the price in yen, followed by the user's currency
+func formatPrice(item alertItem, currency string) string {
+	yen := "¥" + groupDigits(int64(item.PriceYen))
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if currency == "" {
+		currency = defaultCurrency
+	}
+	if rate, ok := currencyRates[currency]; ok && currency != "USD" {
+		amount := float64(item.PriceYen) * rate
+		return fmt.Sprintf("%s (≈ %s)", yen, formatAmount(amount, currency))
+	}
+	if currency == "JPY" || item.PriceUSD <= 0 {
+		return yen
+	}
+	return fmt.Sprintf("%s ($%s)", yen, groupDigits(int64(item.PriceUSD)))
+}
+
+// currencySymbols are shown instead of the code for common currencies
+var currencySymbols = map[string]string{
+	"USD": "$",
+	"EUR": "€",
+	"GBP": "£",
+	"AUD": "A$",
+	"CAD": "C$",
+	"KRW": "₩",
+}
+
+// formatAmount formats an amount of a currency, e.g. €30.50 or 30.50 CHF
+func formatAmount(amount float64, currency string) string {
+	cents := int64(math.Round(amount * 100))
+	value := groupDigits(cents/100) + fmt.Sprintf(".%02d", cents%100)
+	if symbol, ok := currencySymbols[currency]; ok {
+		return symbol + value
+	}
+	return value + " " + currency
+}
+
+// groupDigits formats n with thousands separators, e.g. 12,500
+func groupDigits(n int64) string {
+	s := strconv.FormatInt(n, 10)
+	sign := ""
+	if n < 0 {
+		sign, s = "-", s[1:]
+	}
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return sign + s
+}
+
+// parseCurrencyRates parses CURRENCY_RATES, e.g. "EUR=0.0061,GBP=0.0052"
+func parseCurrencyRates(value string) (map[string]float64, error) {
+	rates := map[string]float64{}
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		code, rate, ok := strings.Cut(field, "=")
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if !ok || len(code) != 3 {
+			return nil, fmt.Errorf("invalid rate %q, expected CODE=rate", field)
+		}
+		n, err := strconv.ParseFloat(strings.TrimSpace(rate), 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid rate %q, expected CODE=rate", field)
+		}
+		rates[code] = n
+	}
+	return rates, nil
+}
+
+// formatLabels turns Sendico labels such as "free_shipping" into a list
+func formatLabels(labels []string) string {
+	var lines []string
+	seen := make(map[string]bool, len(labels))
+	for _, label := range labels {
+		label = strings.Join(strings.FieldsFunc(label, func(r rune) bool {
+			return r == '_' || r == '-' || unicode.IsSpace(r)
+		}), " ")
+		if label == "" || seen[strings.ToLower(label)] {
+			continue
+		}
+		seen[strings.ToLower(label)] = true
+		runes := []rune(label)
+		runes[0] = unicode.ToUpper(runes[0])
+		lines = append(lines, string(runes))
+	}
+	return truncateText(strings.Join(lines, "\n"), 1024)
+}
+
+// truncateText shortens s to at most max characters, marking the cut with "..."
+func truncateText(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max-3]) + "..."
+}
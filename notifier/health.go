@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Staleness thresholds for /healthz and /readyz
+var (
+	healthMaxCycleAge    = 5 * time.Minute  // Max time since the last successful cycle (or of a running cycle)
+	healthMaxSendicoAge  = 30 * time.Minute // Max time since the HMAC secret was last fetched or used successfully
+	healthMaxSupabaseAge = 5 * time.Minute  // Max time since Supabase was last reached
+)
+
+// healthState records what the probes report on
+type healthState struct {
+	mu               sync.Mutex
+	startedAt        time.Time
+	cycleStartedAt   time.Time // zero when no cycle is running
+	lastCycleAt      time.Time // last successful cycle
+	lastCycleError   string
+	lastCycleOverran bool
+	supabaseOKAt     time.Time
+	supabaseError    string
+}
+
+var health = &healthState{startedAt: time.Now()}
+
+func (h *healthState) CycleStarted(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cycleStartedAt = now
+}
+
+func (h *healthState) CycleFinished(now time.Time, err error, overran bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cycleStartedAt = time.Time{}
+	h.lastCycleOverran = overran
+	if err != nil {
+		h.lastCycleError = err.Error()
+		return
+	}
+	h.lastCycleAt = now
+	h.lastCycleError = ""
+}
+
+// SupabaseResult records the outcome of a request to Supabase
+func (h *healthState) SupabaseResult(now time.Time, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err != nil {
+		h.supabaseError = err.Error()
+		return
+	}
+	h.supabaseOKAt = now
+	h.supabaseError = ""
+}
+
+type healthCheck struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type healthReport struct {
+	Status           string                 `json:"status"`
+	LastCycleAt      *time.Time             `json:"last_cycle_at,omitempty"`
+	LastCycleOverran bool                   `json:"last_cycle_overran"`
+	CycleRunningFor  string                 `json:"cycle_running_for,omitempty"`
+	Checks           map[string]healthCheck `json:"checks"`
+}
+
+// report builds the probe response. Liveness only considers whether cycles
+// are completing; readiness also requires Sendico and Supabase to be usable.
+func (h *healthState) report(now time.Time, readiness bool) healthReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r := healthReport{
+		LastCycleOverran: h.lastCycleOverran,
+		Checks:           make(map[string]healthCheck),
+	}
+	if !h.lastCycleAt.IsZero() {
+		lastCycleAt := h.lastCycleAt
+		r.LastCycleAt = &lastCycleAt
+	}
+
+	// A cycle stuck running (e.g. isProcessing never cleared) means we're wedged
+	cycle := healthCheck{OK: true}
+	if !h.cycleStartedAt.IsZero() {
+		running := now.Sub(h.cycleStartedAt)
+		r.CycleRunningFor = running.Round(time.Second).String()
+		if running > healthMaxCycleAge {
+			cycle = healthCheck{OK: false, Detail: "cycle running for " + r.CycleRunningFor}
+		}
+	}
+	if cycle.OK {
+		since := h.lastCycleAt
+		if since.IsZero() {
+			since = h.startedAt // Allow time for the first cycle
+		}
+		if age := now.Sub(since); age > healthMaxCycleAge {
+			cycle = healthCheck{OK: false, Detail: "no successful cycle for " + age.Round(time.Second).String()}
+			if h.lastCycleError != "" {
+				cycle.Detail += ": " + h.lastCycleError
+			}
+		}
+	}
+	r.Checks["cycle"] = cycle
+
+	if readiness {
+		sendico := healthCheck{OK: false, Detail: "client not initialized"}
+		if sendicoClient != nil {
+			if at := sendicoClient.HMACVerifiedAt(); at.IsZero() {
+				sendico.Detail = "no HMAC secret"
+			} else if age := now.Sub(at); age > healthMaxSendicoAge {
+				sendico.Detail = "HMAC secret last verified " + age.Round(time.Second).String() + " ago"
+			} else {
+				sendico = healthCheck{OK: true}
+			}
+		}
+		r.Checks["sendico_hmac"] = sendico
+
+		supabase := healthCheck{OK: true}
+		if h.supabaseOKAt.IsZero() {
+			supabase = healthCheck{OK: false, Detail: "never reached"}
+		} else if age := now.Sub(h.supabaseOKAt); age > healthMaxSupabaseAge {
+			supabase = healthCheck{OK: false, Detail: "last reached " + age.Round(time.Second).String() + " ago"}
+		}
+		if !supabase.OK && h.supabaseError != "" {
+			supabase.Detail += ": " + h.supabaseError
+		}
+		r.Checks["supabase"] = supabase
+	}
+
+	r.Status = "ok"
+	for _, check := range r.Checks {
+		if !check.OK {
+			r.Status = "unavailable"
+		}
+	}
+	return r
+}
+
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealthReport(w, health.report(time.Now(), false))
+}
+
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	writeHealthReport(w, health.report(time.Now(), true))
+}
+
+func writeHealthReport(w http.ResponseWriter, report healthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(report)
+}
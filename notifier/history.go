@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"discord-notifier/postgrest"
+)
+
+// seenHistoryWindow is how far back notification_history is read to find
+// items already delivered for notifications this instance takes over
+var seenHistoryWindow = 30 * 24 * time.Hour
+
+// historyDisabled stops delivered items from being written to
+// notification_history. It is set by NOTIFICATION_HISTORY=false, or when the
+// table doesn't exist.
+var historyDisabled atomic.Bool
+
+// historyEntry is a row of the notification_history table
+type historyEntry struct {
+	AuthUserID     string `json:"auth_user_id"`
+	NotificationID string `json:"notification_id"`
+	Shop           string `json:"shop"`
+	Code           string `json:"code"`
+	Title          string `json:"title"`
+	PriceYen       int    `json:"price_yen"`
+	PriceUSD       int    `json:"price_usd"`
+	URL            string `json:"url"`
+	Image          string `json:"image"`
+	WebhookID      string `json:"webhook_id"`
+	DeliveredAt    string `json:"delivered_at"`
+}
+
+// newHistoryEntries describes items delivered to webhookURL
+func newHistoryEntries(user User, notif Notification, items []SendicoItem, webhookURL string, deliveredAt time.Time) []historyEntry {
+	entries := make([]historyEntry, 0, len(items))
+	for _, item := range items {
+		entries = append(entries, historyEntry{
+			AuthUserID:     user.AuthUserID,
+			NotificationID: notif.ID,
+			Shop:           string(item.Shop),
+			Code:           item.Code,
+			Title:          item.Name,
+			PriceYen:       item.PriceYen,
+			PriceUSD:       item.PriceUSD,
+			URL:            item.URL,
+			Image:          item.Image,
+			WebhookID:      webhookID(webhookURL),
+			DeliveredAt:    deliveredAt.UTC().Format(time.RFC3339),
+		})
+	}
+	return entries
+}
+
+// recordHistory writes delivered items to notification_history. Failures are
+// logged but don't affect delivery.
+func recordHistory(ctx context.Context, entries []historyEntry) {
+	if len(entries) == 0 || historyDisabled.Load() {
+		return
+	}
+
+	err := db.From("notification_history").Insert(ctx, entries)
+	var apiErr *postgrest.Error
+	switch {
+	case err == nil:
+		historyWrites.WithLabelValues("success").Add(float64(len(entries)))
+	case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound:
+		if !historyDisabled.Swap(true) {
+			loggerFrom(ctx).Warn("notification_history table missing, disabling history; run the Supabase migrations", "error", err)
+		}
+	default:
+		historyWrites.WithLabelValues("error").Add(float64(len(entries)))
+		loggerFrom(ctx).Warn("failed to record notification history", "items", len(entries), "error", err)
+	}
+}
+
+// seenHistoryBatch is how many users' history is read per query, keeping
+// the filter within URL length limits
+const seenHistoryBatch = 50
+
+// loadSeenHistory marks items recorded in notification_history as seen for
+// users' notifications, so an instance taking them over (after a restart,
+// leader failover or shard rebalance) doesn't alert on them again. It is a
+// no-op with history disabled.
+func loadSeenHistory(ctx context.Context, users []User) error {
+	if len(users) == 0 || historyDisabled.Load() {
+		return nil
+	}
+	since := time.Now().Add(-seenHistoryWindow).UTC().Format(time.RFC3339)
+
+	loaded := 0
+	for start := 0; start < len(users); start += seenHistoryBatch {
+		batch := users[start:min(start+seenHistoryBatch, len(users))]
+		var userIDs, notificationIDs []string
+		for _, user := range batch {
+			userIDs = append(userIDs, user.AuthUserID)
+			for _, notif := range user.Notifications {
+				notificationIDs = append(notificationIDs, notif.ID)
+			}
+		}
+
+		query := db.From("notification_history").
+			Select("notification_id", "shop", "code").
+			In("auth_user_id", userIDs...).
+			In("notification_id", notificationIDs...).
+			Filter("delivered_at", "gte", since).
+			Order("id", true)
+		rows, err := postgrest.All[historyEntry](ctx, query, 1000)
+		var apiErr *postgrest.Error
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			if !historyDisabled.Swap(true) {
+				loggerFrom(ctx).Warn("notification_history table missing, disabling history; run the Supabase migrations", "error", err)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		seenItemsMu.Lock()
+		for _, row := range rows {
+			seenItems[seenKey(row.NotificationID, row.Shop, row.Code)] = true
+		}
+		seenItemsMu.Unlock()
+		loaded += len(rows)
+	}
+	loggerFrom(ctx).Info("loaded delivered items from notification history", "users", len(users), "items", loaded)
+	return nil
+}
+
+// webhookID returns the ID part of a Discord webhook URL, leaving out the token
+func webhookID(webhookURL string) string {
+	rest, ok := strings.CutPrefix(webhookURL, "https://discord.com/api/webhooks/")
+	if !ok {
+		return ""
+	}
+	id, _, _ := strings.Cut(rest, "/")
+	return id
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"discord-notifier/postgrest"
+
+	"github.com/google/uuid"
+)
+
+// leaseTTL is how long a lease lasts without renewal. Holders renew every
+// third of it, so a dead instance's lease is taken over within leaseTTL.
+var leaseTTL = 15 * time.Second
+
+// leaseTimeFormat stores lease times to the microsecond, as Postgres keeps
+// them, so the stored expiry matches the one the holder goes by. With whole
+// seconds another instance could take a lease up to a second before its
+// holder stops using it.
+const leaseTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// instanceID identifies this process as a lease holder. Defaults to the
+// hostname plus a random suffix so restarted processes don't reuse a lease.
+var instanceID = os.Getenv("INSTANCE_ID")
+
+func init() {
+	if instanceID == "" {
+		host, _ := os.Hostname()
+		instanceID = host + "-" + uuid.NewString()[:8]
+	}
+}
+
+// leaseRow is a row of the notifier_leases table
+type leaseRow struct {
+	Name      string `json:"name"`
+	Holder    string `json:"holder"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+// claimLease takes the named lease for holder, or renews it if holder already
+// has it, until ttl from now. Returns false if another holder's lease hasn't
+// expired. Expiry is judged by the local clock, so instances sharing leases
+// need roughly synchronised clocks.
+//
+// The primary key on name makes taking a lease safe: if two instances both
+// clear an expired lease, only one of their inserts succeeds.
+func claimLease(ctx context.Context, name, holder string, ttl time.Duration) (time.Time, bool, error) {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	expires := now.Add(ttl)
+
+	// Clear the lease if it has expired, whoever held it
+	err := db.From("notifier_leases").
+		Eq("name", name).
+		Filter("expires_at", "lt", now.Format(leaseTimeFormat)).
+		Delete(ctx)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to clear expired lease: %w", err)
+	}
+
+	err = db.From("notifier_leases").Insert(ctx, leaseRow{Name: name, Holder: holder, ExpiresAt: expires.Format(leaseTimeFormat)})
+	var apiErr *postgrest.Error
+	switch {
+	case err == nil:
+		return expires, true, nil
+	case !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusConflict:
+		return time.Time{}, false, fmt.Errorf("failed to take lease: %w", err)
+	}
+
+	// Someone holds it: extend it if that's us, then check who it is
+	err = db.From("notifier_leases").
+		Eq("name", name).
+		Eq("holder", holder).
+		Update(ctx, map[string]string{"expires_at": expires.Format(leaseTimeFormat)})
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to renew lease: %w", err)
+	}
+	var rows []leaseRow
+	if _, err := db.From("notifier_leases").Select("holder").Eq("name", name).Execute(ctx, &rows); err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to read lease: %w", err)
+	}
+	if len(rows) == 1 && rows[0].Holder == holder {
+		return expires, true, nil
+	}
+	return time.Time{}, false, nil
+}
+
+// releaseLease gives up the named lease if holder has it, so another instance
+// can take it without waiting for it to expire
+func releaseLease(ctx context.Context, name, holder string) error {
+	return db.From("notifier_leases").Eq("name", name).Eq("holder", holder).Delete(ctx)
+}
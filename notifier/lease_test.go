@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"discord-notifier/postgrest"
+	"discord-notifier/postgrest/postgresttest"
+)
+
+// useTestDB points db at a fake PostgREST server for the test
+func useTestDB(t *testing.T) *postgresttest.Server {
+	t.Helper()
+	srv := postgresttest.NewServer()
+	previous := db
+	t.Cleanup(func() {
+		db = previous
+		srv.Close()
+	})
+	db = postgrest.NewClient(srv.URL, "key")
+	db.RetryBackoff = time.Millisecond
+	return srv
+}
+
+func useLeaseTable(t *testing.T, rows ...postgresttest.Row) *postgresttest.Server {
+	t.Helper()
+	srv := useTestDB(t)
+	srv.SetRows("notifier_leases", rows)
+	srv.SetPrimaryKey("notifier_leases", "name")
+	return srv
+}
+
+func leaseHolder(t *testing.T, srv *postgresttest.Server, name string) string {
+	t.Helper()
+	for _, row := range srv.Rows("notifier_leases") {
+		if row["name"] == name {
+			holder, _ := row["holder"].(string)
+			return holder
+		}
+	}
+	return ""
+}
+
+func TestClaimLeaseFree(t *testing.T) {
+	srv := useLeaseTable(t)
+	before := time.Now()
+
+	expires, ok, err := claimLease(context.Background(), "shard-0-of-2", "a", time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("claimLease() = %v, %v, want the free lease taken", ok, err)
+	}
+	if expires.Before(before.Add(time.Minute - time.Second)) {
+		t.Errorf("expires = %v, want a minute from now", expires)
+	}
+	if got := leaseHolder(t, srv, "shard-0-of-2"); got != "a" {
+		t.Errorf("holder = %q, want a", got)
+	}
+}
+
+func TestClaimLeaseRenew(t *testing.T) {
+	srv := useLeaseTable(t)
+	ctx := context.Background()
+	if _, ok, err := claimLease(ctx, "shard-0-of-2", "a", time.Minute); err != nil || !ok {
+		t.Fatalf("first claim = %v, %v", ok, err)
+	}
+
+	expires, ok, err := claimLease(ctx, "shard-0-of-2", "a", time.Hour)
+	if err != nil || !ok {
+		t.Fatalf("renewal = %v, %v, want the holder to keep its lease", ok, err)
+	}
+	rows := srv.Rows("notifier_leases")
+	if len(rows) != 1 {
+		t.Fatalf("%d lease rows, want 1", len(rows))
+	}
+	stored, err := time.Parse(leaseTimeFormat, rows[0]["expires_at"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Stored to the microsecond, exactly the expiry the holder goes by
+	if stored.Before(time.Now().Add(59*time.Minute)) || !stored.Equal(expires) {
+		t.Errorf("stored expiry %v, want the renewed expiry %v", stored, expires)
+	}
+}
+
+func TestClaimLeaseHeldByAnother(t *testing.T) {
+	future := time.Now().Add(time.Minute).UTC().Format(leaseTimeFormat)
+	srv := useLeaseTable(t, postgresttest.Row{"name": "shard-0-of-2", "holder": "a", "expires_at": future})
+
+	_, ok, err := claimLease(context.Background(), "shard-0-of-2", "b", time.Minute)
+	if err != nil || ok {
+		t.Fatalf("claimLease() = %v, %v, want false while a holds the lease", ok, err)
+	}
+	rows := srv.Rows("notifier_leases")
+	if len(rows) != 1 || rows[0]["holder"] != "a" || rows[0]["expires_at"] != future {
+		t.Errorf("lease rows = %v, want a's lease untouched", rows)
+	}
+}
+
+func TestClaimLeaseTakeover(t *testing.T) {
+	past := time.Now().Add(-time.Minute).UTC().Format(leaseTimeFormat)
+	srv := useLeaseTable(t,
+		postgresttest.Row{"name": "shard-0-of-2", "holder": "a", "expires_at": past},
+		postgresttest.Row{"name": "shard-1-of-2", "holder": "a", "expires_at": past},
+	)
+
+	if _, ok, err := claimLease(context.Background(), "shard-0-of-2", "b", time.Minute); err != nil || !ok {
+		t.Fatalf("claimLease() = %v, %v, want the expired lease taken over", ok, err)
+	}
+	if got := leaseHolder(t, srv, "shard-0-of-2"); got != "b" {
+		t.Errorf("holder = %q, want b", got)
+	}
+	// Only the claimed lease is cleared
+	if got := leaseHolder(t, srv, "shard-1-of-2"); got != "a" {
+		t.Errorf("shard-1-of-2 holder = %q, want a", got)
+	}
+}
+
+func TestClaimLeaseError(t *testing.T) {
+	srv := useLeaseTable(t)
+	srv.FailNext(http.StatusForbidden)
+
+	if _, ok, err := claimLease(context.Background(), "shard-0-of-2", "a", time.Minute); err == nil || ok {
+		t.Errorf("claimLease() = %v, %v, want an error", ok, err)
+	}
+}
+
+func TestReleaseLease(t *testing.T) {
+	future := time.Now().Add(time.Minute).UTC().Format(leaseTimeFormat)
+	srv := useLeaseTable(t,
+		postgresttest.Row{"name": "leader", "holder": "a", "expires_at": future},
+		postgresttest.Row{"name": "shard-0-of-2", "holder": "b", "expires_at": future},
+	)
+	ctx := context.Background()
+
+	// Releasing someone else's lease does nothing
+	if err := releaseLease(ctx, "shard-0-of-2", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := releaseLease(ctx, "leader", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if got := leaseHolder(t, srv, "leader"); got != "" {
+		t.Errorf("leader still held by %q after release", got)
+	}
+	if got := leaseHolder(t, srv, "shard-0-of-2"); got != "b" {
+		t.Errorf("shard-0-of-2 holder = %q, want b", got)
+	}
+
+	// The next instance takes the lease at once
+	if _, ok, err := claimLease(ctx, "leader", "c", time.Minute); err != nil || !ok {
+		t.Errorf("claimLease() after release = %v, %v, want it taken", ok, err)
+	}
+}
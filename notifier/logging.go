@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Log redaction policy: emails keep their first character and domain, Discord
+// webhook URLs keep their ID but lose the token. Applied to every attribute
+// and message so secrets embedded in error strings are caught too.
+var (
+	emailPattern   = regexp.MustCompile(`([A-Za-z0-9._%+\-])[A-Za-z0-9._%+\-]*@([A-Za-z0-9.\-]+\.[A-Za-z]{2,})`)
+	webhookPattern = regexp.MustCompile(`(https://(?:\w+\.)?discord(?:app)?\.com/api/webhooks/\d+/)[A-Za-z0-9_\-]+`)
+
+	logRedact = true
+)
+
+// redact masks emails and webhook tokens in s
+func redact(s string) string {
+	s = webhookPattern.ReplaceAllString(s, "${1}[redacted]")
+	return emailPattern.ReplaceAllString(s, "${1}***@${2}")
+}
+
+// setupLogging installs the default slog logger. format is "text" or "json";
+// level is one of debug, info, warn or error.
+func setupLogging(w io.Writer, format, level string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+
+	opts := &slog.HandlerOptions{
+		Level: lvl,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if !logRedact {
+				return a
+			}
+			switch a.Value.Kind() {
+			case slog.KindString:
+				a.Value = slog.StringValue(redact(a.Value.String()))
+			case slog.KindAny:
+				if err, ok := a.Value.Any().(error); ok {
+					a.Value = slog.StringValue(redact(err.Error()))
+				}
+			}
+			return a
+		},
+	}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "", "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid log format %q", format)
+	}
+
+	// Also routes anything written with the log package through the handler
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// fatal logs msg at error level and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+type loggerKey struct{}
+
+// withLogger returns a context carrying logger, used to thread fields such as
+// cycle_id and user_id through the notification pipeline
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// loggerFrom returns the logger stored in ctx, or the default logger
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// newCycleID returns a short random identifier for a notification cycle
+func newCycleID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
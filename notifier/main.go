@@ -11,14 +11,22 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"text/template"
 	"time"
+
+	"discord-notifier/postgrest"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Notification struct {
@@ -26,7 +34,13 @@ type Notification struct {
 	SearchTerm string   `json:"searchTerm"`
 	Markets    []string `json:"markets"`
 	Webhooks   []string `json:"webhooks,omitempty"` // Per-notification webhooks
+	Aliases    []string `json:"aliases,omitempty"`  // Extra search variants (romaji, katakana, nicknames)
+	MinPrice   *int     `json:"minPrice,omitempty"` // Price filters in yen
+	MaxPrice   *int     `json:"maxPrice,omitempty"`
 	CreatedAt  string   `json:"createdAt"`
+
+	Template *MessageTemplate `json:"template,omitempty"` // Custom message format (nil = default)
+	Mentions *MentionConfig   `json:"mentions,omitempty"` // Who to ping for priority listings (nil = nobody)
 }
 
 type User struct {
@@ -37,20 +51,25 @@ type User struct {
 	DiscordNotifications  json.RawMessage `json:"discord_notifications"` // Store as raw JSON first
 	SubscriptionActive    bool            `json:"notifications_subscription_active"`
 	SubscriptionExpiresAt *string         `json:"notifications_subscription_expires_at"`
+	Tier                  string          `json:"notifications_tier"`     // Subscription tier (empty = default)
+	Currency              string          `json:"notifications_currency"` // Currency prices are shown in (empty = USD)
 
-	// Parsed notifications (populated after unmarshalling)
+	// Parsed notifications (populated after unmarshalling), trimmed to the tier's limits
 	Notifications []Notification
+	LimitNotices  []string `json:"-"` // What the tier's limits caused to be skipped
 }
 
 type DiscordEmbed struct {
 	Title       string                 `json:"title"`
-	Description string                 `json:"description"`
+	Description string                 `json:"description,omitempty"`
 	URL         string                 `json:"url,omitempty"`
 	Color       int                    `json:"color"`
 	Fields      []DiscordEmbedField    `json:"fields,omitempty"`
 	Timestamp   string                 `json:"timestamp,omitempty"`
 	Footer      map[string]interface{} `json:"footer,omitempty"`
 	Image       map[string]string      `json:"image,omitempty"`
+	Thumbnail   map[string]string      `json:"thumbnail,omitempty"`
+	Author      map[string]string      `json:"author,omitempty"`
 }
 
 type DiscordEmbedField struct {
@@ -60,16 +79,32 @@ type DiscordEmbedField struct {
 }
 
 type DiscordWebhookPayload struct {
-	Content string         `json:"content,omitempty"`
-	Embeds  []DiscordEmbed `json:"embeds,omitempty"`
+	Content         string                  `json:"content,omitempty"`
+	Embeds          []DiscordEmbed          `json:"embeds,omitempty"`
+	AllowedMentions *DiscordAllowedMentions `json:"allowed_mentions,omitempty"`
 }
 
 var (
 	supabaseURL   = "https://wbpfuuiznsmysbskywdx.supabase.co"
 	supabaseKey   = ""
-	pollInterval  = 1 * time.Minute // Check every 1 minute (fast notifications)
+	db            *postgrest.Client // PostgREST API of the Supabase project
+	httpAddr      = ":8080"         // Metrics and health endpoints (empty disables the HTTP server)
+	pollInterval  = 1 * time.Minute // Re-read subscribers from Supabase every minute
 	sendicoClient *SendicoClient
 
+	// Adaptive per-notification polling: searches start at pollInterval, hot
+	// ones speed up as far as their tier allows, dead ones back off to
+	// maxPollInterval
+	pollScheduler          *PollScheduler
+	maxPollInterval        = 15 * time.Minute
+	defaultMinPollInterval = 30 * time.Second // For tiers without a poll limit
+	minSchedulerWait       = 5 * time.Second  // Don't wake the scheduler more often than this
+
+	// Subscribers from the last Supabase sync
+	subscribers     []User
+	subscribersMu   sync.RWMutex
+	lastSubscribers time.Time
+
 	// Cycle lock to prevent overlapping processing cycles
 	processingMu sync.Mutex
 	isProcessing bool
@@ -88,12 +123,22 @@ var (
 	seenItemsMu sync.RWMutex
 
 	// Translation cache to avoid duplicate API calls (many users search same terms)
-	translationCache   = make(map[string]string)
-	translationCacheMu sync.RWMutex
+	// Persisted to disk so restarts don't re-translate every saved search
+	translationCache     *TranslationCache
+	translationCacheSize = 1000
+	translationCacheTTL  = 7 * 24 * time.Hour
+	translationCachePath = "translation_cache.json"
 
 	// Concurrency limits
-	maxConcurrentUsers    = 10 // Process 10 users in parallel
-	maxConcurrentSearches = 5  // Max 5 concurrent Sendico searches
+	maxConcurrentUsers = 10 // Process 10 users in parallel
+
+	// Global Sendico request budget shared by all users (requests per second)
+	// Rakuten is stricter and gets its own sub-limit
+	sendicoRequestsPerSecond = 2.0
+	sendicoBurst             = 3
+	sendicoShopRateLimits    = map[SendicoShop]float64{
+		SendicoRakuten: 0.5,
+	}
 
 	// Supported markets - only these markets will be processed by the notifier
 	// This matches the marketUrls object in index.html
@@ -136,436 +181,670 @@ var (
 )
 
 func main() {
-	// Get API key from environment or prompt
-	// NOTE: Use SERVICE_ROLE_KEY for notifier (bypasses RLS to read all users)
-	// Get it from: Supabase Dashboard → Project Settings → API → service_role key
-	if key := os.Getenv("SUPABASE_SERVICE_ROLE_KEY"); key != "" {
-		supabaseKey = key
-	} else if key := os.Getenv("SUPABASE_ANON_KEY"); key != "" {
-		supabaseKey = key
-		log.Printf("⚠️  WARNING: Using ANON_KEY. For production, use SERVICE_ROLE_KEY to bypass RLS.")
-	} else {
-		fmt.Print("Enter your Supabase Service Role Key (or Anon Key): ")
-		fmt.Scanln(&supabaseKey)
-		if supabaseKey == "" {
-			log.Fatal("❌ API key is required")
+	logFormat := os.Getenv("LOG_FORMAT")
+	logLevel := os.Getenv("LOG_LEVEL")
+	if logLevel == "" {
+		logLevel = "info"
+	}
+	if os.Getenv("LOG_REDACT") == "false" {
+		logRedact = false
+	}
+	if err := setupLogging(os.Stderr, logFormat, logLevel); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid logging configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	if path, ok := os.LookupEnv("TRANSLATION_CACHE_PATH"); ok {
+		translationCachePath = path // Empty disables persistence
+	}
+	durationFromEnv("TRANSLATION_CACHE_TTL", &translationCacheTTL)
+	durationFromEnv("POLL_MAX_INTERVAL", &maxPollInterval)
+	durationFromEnv("HEALTH_MAX_CYCLE_AGE", &healthMaxCycleAge)
+	durationFromEnv("HEALTH_MAX_SENDICO_AGE", &healthMaxSendicoAge)
+	durationFromEnv("HEALTH_MAX_SUPABASE_AGE", &healthMaxSupabaseAge)
+	if rps := os.Getenv("SENDICO_RPS"); rps != "" {
+		v, err := strconv.ParseFloat(rps, 64)
+		if err != nil || v <= 0 {
+			fatal("invalid SENDICO_RPS", "value", rps)
+		}
+		sendicoRequestsPerSecond = v
+	}
+	if itemURL, ok := os.LookupEnv("SENDICO_ITEM_URL"); ok {
+		sendicoItemURL = itemURL
+	}
+	if limits := os.Getenv("TIER_LIMITS"); limits != "" {
+		parsed, err := parseTierLimits(limits)
+		if err != nil {
+			fatal("invalid TIER_LIMITS, expected e.g. default:notifications=10,poll=1m;pro:notifications=50", "value", limits, "error", err)
+		}
+		tiers = parsed
+	}
+	if rates := os.Getenv("CURRENCY_RATES"); rates != "" {
+		parsed, err := parseCurrencyRates(rates)
+		if err != nil {
+			fatal("invalid CURRENCY_RATES, expected e.g. EUR=0.0061,GBP=0.0052", "value", rates, "error", err)
+		}
+		currencyRates = parsed
+	}
+	titleTranslationDisabled = os.Getenv("TRANSLATE_TITLES") == "false"
+
+	if source := os.Getenv("NOTIFICATIONS_SOURCE"); source != "" {
+		if source != notificationsSourceBlob && source != notificationsSourceTable {
+			fatal("invalid NOTIFICATIONS_SOURCE, expected blob or table", "value", source)
+		}
+		notificationsSource = source
+	}
+
+	stripeSecretKey = os.Getenv("STRIPE_SECRET_KEY")
+	if base := os.Getenv("STRIPE_API_BASE"); base != "" {
+		stripeAPIBase = base
+	}
+	durationFromEnv("STRIPE_RECONCILE_INTERVAL", &stripeReconcileInterval)
+
+	// Subcommands run standalone instead of the notifier loop
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "preview":
+			runPreview(os.Args[2:])
+			return
+		case "test-webhook":
+			runTestWebhook(os.Args[2:])
+			return
+		case "migrate-notifications":
+			initSupabase()
+			runMigrateNotifications(os.Args[2:])
+			return
+		case "reconcile-stripe":
+			initSupabase()
+			runReconcileStripe(os.Args[2:])
+			return
+		default:
+			fatal("unknown command", "command", os.Args[1])
+		}
+	}
+
+	initSupabase()
+
+	if addr, ok := os.LookupEnv("HTTP_ADDR"); ok {
+		httpAddr = addr
+	}
+	adminToken = os.Getenv("ADMIN_TOKEN")
+	if origin := os.Getenv("CORS_ALLOW_ORIGIN"); origin != "" {
+		corsAllowOrigin = origin
+	}
+	if mode := os.Getenv("SUBSCRIBER_SYNC"); mode != "" {
+		if mode != syncModePoll && mode != syncModeRealtime {
+			fatal("invalid SUBSCRIBER_SYNC, expected poll or realtime", "value", mode)
 		}
+		subscriberSyncMode = mode
 	}
+	durationFromEnv("REALTIME_RESYNC_INTERVAL", &realtimeResyncInterval)
+	if os.Getenv("NOTIFICATION_HISTORY") == "false" {
+		historyDisabled.Store(true)
+	}
+	durationFromEnv("SEEN_HISTORY_WINDOW", &seenHistoryWindow)
+	if os.Getenv("NOTIFICATION_STATUS") == "false" {
+		statusDisabled.Store(true)
+	}
+	if count := os.Getenv("SHARD_COUNT"); count != "" {
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 1 {
+			fatal("invalid SHARD_COUNT", "value", count)
+		}
+		shardCount = n
+	}
+	if os.Getenv("LEADER_ELECTION") == "true" {
+		if shardCount > 1 {
+			fatal("LEADER_ELECTION requires SHARD_COUNT=1; sharded instances with SHARD_INDEX=auto already fail over")
+		}
+		leaderElection = true
+	}
+	switch index := os.Getenv("SHARD_INDEX"); {
+	case leaderElection, index == "auto", index == "" && shardCount > 1:
+		shardAuto = true
+		shard.set(-1, time.Time{})
+	case index != "":
+		n, err := strconv.Atoi(index)
+		if err != nil || n < 0 || n >= shardCount {
+			fatal("invalid SHARD_INDEX, expected auto or 0 to SHARD_COUNT-1", "value", index, "shard_count", shardCount)
+		}
+		shard.set(n, time.Time{})
+	default:
+		shard.set(0, time.Time{})
+	}
+	durationFromEnv("LEASE_TTL", &leaseTTL)
+	durationFromEnv("SUBSCRIPTION_GRACE_PERIOD", &subscriptionGracePeriod)
+	if days, ok := os.LookupEnv("EXPIRY_WARNING_DAYS"); ok {
+		parsed, err := parseExpiryWarningDays(days)
+		if err != nil {
+			fatal("invalid EXPIRY_WARNING_DAYS, expected a comma-separated list of days", "value", days, "error", err)
+		}
+		expiryWarningDays = parsed
+	}
+	expiryWarningsDisabled = os.Getenv("EXPIRY_WARNINGS") == "false"
+
+	slog.Info("starting discord notifier", "supabase_url", supabaseURL, "poll_interval", pollInterval)
+
+	exitOnSignal()
 
-	log.Printf("🚀 Starting Discord Notifier")
-	log.Printf("📡 Supabase URL: %s", supabaseURL)
-	log.Printf("⏱️  Poll interval: %v", pollInterval)
-	log.Printf("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	if tracingEnabled() {
+		shutdownTracing, err := setupTracing(context.Background())
+		if err != nil {
+			fatal("failed to set up tracing", "error", err)
+		}
+		onShutdown(func(ctx context.Context) {
+			if err := shutdownTracing(ctx); err != nil {
+				slog.Warn("failed to flush traces", "error", err)
+			}
+		})
+		slog.Info("OpenTelemetry tracing enabled")
+	}
 
 	// Verify database schema before proceeding
-	log.Printf("🔍 Verifying database schema...")
+	slog.Info("verifying database schema")
 	if err := verifyDatabaseSchema(); err != nil {
-		log.Fatalf("❌ Database schema verification failed: %v", err)
+		fatal("database schema verification failed", "error", err)
 	}
-	log.Printf("✅ Database schema verified")
+	health.SupabaseResult(time.Now(), nil)
+	slog.Info("database schema verified")
 
-	// Initialize Sendico client
-	log.Printf("🔧 Initializing Sendico client...")
-	var err error
-	sendicoClient, err = NewSendicoClient()
-	if err != nil {
-		log.Fatalf("❌ Failed to initialize Sendico client: %v", err)
+	initSendico()
+
+	pollScheduler = NewPollScheduler(pollInterval, maxPollInterval)
+
+	if leaderElection {
+		slog.Info("leader election enabled, only the leader checks subscribers", "lease_ttl", leaseTTL, "instance_id", instanceID)
+	} else if shardAuto {
+		slog.Info("leasing a shard of subscribers", "shard_count", shardCount, "lease_ttl", leaseTTL, "instance_id", instanceID)
+	}
+	if shardAuto {
+		releaseShardOnExit()
+		go runShardLease(context.Background())
+	} else if shardCount > 1 {
+		index, _ := shard.Current()
+		slog.Info("checking a fixed shard of subscribers", "shard", index, "shard_count", shardCount)
 	}
-	log.Printf("✅ Sendico client initialized")
 
-	// Run immediately
-	processAllNotifications()
+	if stripeSecretKey != "" && stripeReconcileInterval > 0 {
+		slog.Info("reconciling subscriptions with Stripe", "interval", stripeReconcileInterval, "api_base", stripeAPIBase)
+		go runStripeReconciler(context.Background())
+	}
 
-	// Then run on interval
-	ticker := time.NewTicker(pollInterval)
-	defer ticker.Stop()
+	if subscriberSyncMode == syncModeRealtime {
+		slog.Info("subscriber sync via Supabase Realtime", "resync_interval", realtimeResyncInterval)
+		go runRealtime(context.Background())
+	}
+
+	if httpAddr != "" {
+		startHTTPServer(httpAddr)
+	}
 
+	// Run due notifications, then sleep until the next one is due
 	for {
-		<-ticker.C
 		processAllNotifications()
+
+		wait := subscriberResyncInterval() - time.Since(lastSubscribers)
+		if next := pollScheduler.NextRun(); !next.IsZero() && time.Until(next) < wait {
+			wait = time.Until(next)
+		}
+		// Run a cycle, even with nothing due, well within the liveness
+		// threshold so an idle loop doesn't fail /healthz
+		if wait > healthMaxCycleAge/2 {
+			wait = healthMaxCycleAge / 2
+		}
+		if wait < minSchedulerWait {
+			wait = minSchedulerWait
+		}
+		select {
+		case <-time.After(wait):
+		case <-subscribersChanged:
+		}
 	}
 }
 
-func processAllNotifications() {
-	// Prevent overlapping cycles - skip if previous cycle still running
+// startProcessing marks a cycle as running, returning false if one already is
+func startProcessing() bool {
 	processingMu.Lock()
+	defer processingMu.Unlock()
 	if isProcessing {
-		log.Printf("⏭️  Previous cycle still running, skipping this check")
-		processingMu.Unlock()
-		return
+		return false
 	}
 	isProcessing = true
+	return true
+}
+
+func finishProcessing() {
+	processingMu.Lock()
+	isProcessing = false
 	processingMu.Unlock()
+}
 
-	defer func() {
-		processingMu.Lock()
-		isProcessing = false
-		processingMu.Unlock()
-	}()
+func processAllNotifications() {
+	// Prevent overlapping cycles - skip if previous cycle still running
+	if !startProcessing() {
+		slog.Warn("previous cycle still running, skipping this check")
+		cyclesSkipped.Inc()
+		return
+	}
+	defer finishProcessing()
 
 	startTime := time.Now()
-	log.Printf("🔄 Starting notification cycle...")
+	health.CycleStarted(startTime)
 
-	users, err := fetchActiveSubscribers()
-	if err != nil {
-		log.Printf("❌ Error fetching users: %v", err)
+	cycleID := newCycleID()
+	logger := slog.With("cycle_id", cycleID)
+	ctx, span := tracer.Start(withLogger(context.Background(), logger), "notifier.cycle",
+		trace.WithAttributes(attribute.String("notifier.cycle_id", cycleID)))
+
+	var cycleErr error
+	defer func() {
+		health.CycleFinished(time.Now(), cycleErr, time.Since(startTime) > pollInterval)
+		endSpan(span, cycleErr)
+	}()
+
+	// A standby instance waiting for a shard lease has nothing to check
+	if _, held := shard.Current(); !held {
+		logger.Debug("no shard held, skipping cycle")
 		return
 	}
 
+	// Re-read subscribers at most once per resync interval, or when realtime
+	// updates may have been missed
+	if realtime.TakeResync() || time.Since(lastSubscribers) >= subscriberResyncInterval() {
+		logger.Debug("syncing subscribers")
+		changesBefore := realtime.Changes()
+		previous := currentSubscribers()
+		users, err := fetchActiveSubscribers(ctx)
+		health.SupabaseResult(time.Now(), err)
+		if err != nil {
+			logger.Error("error fetching users", "error", err)
+			cycleErr = err
+			return
+		}
+		lastSubscribers = time.Now()
+
+		// Expired subscribers are still warned, so this comes before filtering
+		sendExpiryWarnings(ctx, users)
+		deactivated.Add(droppedSubscribers(previous, users)...)
+		warnInactiveSubscribers(ctx)
+
+		// Filter to only active subscriptions
+		activeUsers := make([]User, 0, len(users))
+		for _, user := range users {
+			if isSubscriptionActive(user) {
+				activeUsers = append(activeUsers, user)
+			} else {
+				logger.Info("skipping user, subscription expired", "user_id", user.AuthUserID, "email", user.Email)
+			}
+		}
+		subscribersMu.Lock()
+		subscribers = activeUsers
+		subscribersMu.Unlock()
+		if realtime.Changes() != changesBefore {
+			// A realtime update landed mid-fetch and may have been overwritten
+			realtime.RequestResync()
+		}
+		activeSubscribers.Set(float64(len(activeUsers)))
+		pollScheduler.Sync(activeUsers, time.Now())
+		logger.Info("synced subscribers", "found", len(users), "active", len(activeUsers))
+	}
+
+	users := currentSubscribers()
 	if len(users) == 0 {
-		log.Printf("ℹ️  No active subscribers found")
+		logger.Debug("no active subscriptions found")
 		return
 	}
 
-	log.Printf("✅ Found %d subscriber(s)", len(users))
-
-	// Filter to only active subscriptions
-	activeUsers := make([]User, 0, len(users))
-	for _, user := range users {
-		if isSubscriptionActive(user) {
-			activeUsers = append(activeUsers, user)
-		} else {
-			log.Printf("⏭️  Skipping %s - subscription expired", user.Email)
-		}
+	dueUsers := pollScheduler.Due(users, time.Now())
+	if len(dueUsers) == 0 {
+		return
 	}
 
-	if len(activeUsers) == 0 {
-		log.Printf("ℹ️  No active subscriptions found")
+	// Another instance may have alerted on these notifications before this
+	// one took them over; retry next time rather than alert again
+	if err := loadSeenHistory(ctx, pollScheduler.Unchecked(dueUsers)); err != nil {
+		logger.Error("error loading notification history", "error", err)
+		cycleErr = err
 		return
 	}
 
-	log.Printf("🚀 Processing %d active subscriber(s) in parallel (max %d concurrent)", len(activeUsers), maxConcurrentUsers)
-
-	// Process users in parallel with worker pool
-	userSem := make(chan struct{}, maxConcurrentUsers)
-	var wg sync.WaitGroup
+	dueCount := 0
+	for _, user := range dueUsers {
+		dueCount += len(user.Notifications)
+	}
 
-	for i, user := range activeUsers {
-		wg.Add(1)
-		go func(idx int, u User) {
-			defer wg.Done()
+	logger.Info("starting notification cycle",
+		"due_notifications", dueCount, "users", len(dueUsers), "max_concurrent_users", maxConcurrentUsers)
+	span.SetAttributes(attribute.Int("notifier.due_notifications", dueCount), attribute.Int("notifier.users", len(dueUsers)))
+	newItems := runNotifications(ctx, dueUsers)
+
+	// Reschedule every due notification based on how many new items it found
+	now := time.Now()
+	for _, user := range dueUsers {
+		for _, notif := range user.Notifications {
+			if count, ok := newItems[pollKey(user.AuthUserID, notif.ID)]; ok {
+				pollScheduler.Record(user, notif.ID, count, now)
+			} else {
+				pollScheduler.Failed(user, notif.ID, now)
+			}
+		}
+	}
 
-			// Acquire semaphore
-			userSem <- struct{}{}
-			defer func() { <-userSem }()
+	saveRunStatuses(ctx)
 
-			log.Printf("👤 Processing: %s (%s) [%d/%d]", u.Username, u.Email, idx+1, len(activeUsers))
-			processUserNotifications(u)
-		}(i, user)
+	if err := translationCache.Save(); err != nil {
+		logger.Warn("failed to save translation cache", "error", err)
 	}
 
-	wg.Wait()
 	duration := time.Since(startTime)
-	log.Printf("✅ Finished processing all subscribers (took %v)", duration)
+	logger.Info("finished notification cycle", "duration", duration)
+	cycleDuration.Observe(duration.Seconds())
 
 	// Warn if processing took longer than poll interval
 	if duration > pollInterval {
-		log.Printf("⚠️  WARNING: Processing took longer than poll interval! Consider reducing user count or increasing interval.")
+		cycleOverruns.Inc()
+		logger.Warn("cycle took longer than poll interval; consider reducing user count or increasing interval",
+			"duration", duration, "poll_interval", pollInterval)
 	}
 }
 
-// verifyDatabaseSchema checks that the database table has the expected structure
-func verifyDatabaseSchema() error {
-	log.Printf("   Checking database connection and schema...")
-	
-	// Supabase uses PostgreSQL, verify we can connect and query
-	// Query the table with a simple select to verify it exists and has required columns
-	url := fmt.Sprintf("%s/rest/v1/unlocked_users?select=auth_user_id,email,username,discord_webhook_url,discord_notifications,notifications_subscription_active,notifications_subscription_expires_at&limit=1", supabaseURL)
+// currentSubscribers returns the active subscribers from the last sync
+func currentSubscribers() []User {
+	subscribersMu.RLock()
+	defer subscribersMu.RUnlock()
+	return subscribers
+}
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+// initSupabase reads the Supabase API key and sets up the PostgREST client
+func initSupabase() {
+	// Get API key from environment or prompt
+	// NOTE: Use SERVICE_ROLE_KEY for notifier (bypasses RLS to read all users)
+	// Get it from: Supabase Dashboard → Project Settings → API → service_role key
+	if key := os.Getenv("SUPABASE_SERVICE_ROLE_KEY"); key != "" {
+		supabaseKey = key
+	} else if key := os.Getenv("SUPABASE_ANON_KEY"); key != "" {
+		supabaseKey = key
+		slog.Warn("using anon key; use SUPABASE_SERVICE_ROLE_KEY in production to bypass RLS")
+	} else {
+		fmt.Print("Enter your Supabase Service Role Key (or Anon Key): ")
+		fmt.Scanln(&supabaseKey)
+		if supabaseKey == "" {
+			fatal("API key is required")
+		}
 	}
-	req.Header.Set("apikey", supabaseKey)
-	req.Header.Set("Authorization", "Bearer "+supabaseKey)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Prefer", "return=representation")
+	db = postgrest.NewClient(supabaseURL+"/rest/v1", supabaseKey)
+}
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+// initSendico sets up the rate-limited Sendico client and the translation cache
+func initSendico() {
+	scheduler := NewRequestScheduler(sendicoRequestsPerSecond, sendicoBurst, sendicoShopRateLimits)
+
+	var err error
+	sendicoClient, err = NewSendicoClient(scheduler)
 	if err != nil {
-		return fmt.Errorf("failed to connect to database: %w", err)
+		fatal("failed to initialize sendico client", "error", err)
 	}
-	defer resp.Body.Close()
-
-	// Check response status
-	if resp.StatusCode == 404 {
-		return fmt.Errorf("table 'unlocked_users' does not exist - please run database/schema.sql in Supabase SQL Editor")
+	slog.Info("sendico client initialized",
+		"requests_per_second", sendicoRequestsPerSecond, "burst", sendicoBurst, "shop_limits", sendicoShopRateLimits)
+
+	translationCache = NewTranslationCache(translationCacheSize, translationCacheTTL, translationCachePath)
+	if err := translationCache.Load(); err != nil {
+		slog.Warn("failed to load translation cache", "path", translationCachePath, "error", err)
+	} else if translationCachePath != "" {
+		slog.Info("loaded translation cache", "path", translationCachePath, "entries", translationCache.Len())
 	}
+}
 
-	if resp.StatusCode == 401 || resp.StatusCode == 403 {
-		return fmt.Errorf("authentication failed (status %d) - check your API key and ensure it has proper permissions", resp.StatusCode)
+// durationFromEnv overrides target with the duration in the named environment variable, if set
+func durationFromEnv(name string, target *time.Duration) {
+	value := os.Getenv(name)
+	if value == "" {
+		return
 	}
-
-	if resp.StatusCode != 200 && resp.StatusCode != 206 {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("database query failed (status %d): %s", resp.StatusCode, string(body))
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		fatal("invalid duration in environment", "variable", name, "error", err)
 	}
+	*target = d
+}
 
-	// Try to decode to verify column types match
+// verifyDatabaseSchema checks that the database table has the expected structure
+func verifyDatabaseSchema() error {
+	slog.Debug("checking database connection and schema")
+	ctx := context.Background()
+
+	// Supabase uses PostgreSQL, verify we can connect and query
+	// Query the table with a simple select to verify it exists and has required columns
 	var testUsers []User
-	bodyBytes, _ := io.ReadAll(resp.Body)
-	if err := json.Unmarshal(bodyBytes, &testUsers); err != nil {
-		// Check if it's an empty array (which is fine)
-		var emptyArray []interface{}
-		if json.Unmarshal(bodyBytes, &emptyArray) == nil {
-			log.Printf("   ✓ Database table 'unlocked_users' exists (empty table)")
-		} else {
-			return fmt.Errorf("failed to parse database response - schema may be incorrect: %w\nResponse: %s", err, string(bodyBytes))
-		}
+	_, err := db.From("unlocked_users").
+		Select(subscriberColumns...).
+		Limit(1).
+		Execute(ctx, &testUsers)
+
+	var apiErr *postgrest.Error
+	switch {
+	case errors.As(err, &apiErr) && apiErr.StatusCode == 404:
+		return fmt.Errorf("table 'unlocked_users' does not exist - please run database/schema.sql in Supabase SQL Editor")
+	case errors.As(err, &apiErr) && (apiErr.StatusCode == 401 || apiErr.StatusCode == 403):
+		return fmt.Errorf("authentication failed (status %d) - check your API key and ensure it has proper permissions", apiErr.StatusCode)
+	case errors.As(err, &apiErr):
+		return fmt.Errorf("database query failed (status %d): %s", apiErr.StatusCode, apiErr.Body)
+	case err != nil:
+		return fmt.Errorf("failed to query database - schema may be incorrect: %w", err)
+	}
+
+	if len(testUsers) == 0 {
+		slog.Info("database table unlocked_users exists (empty table)")
 	} else {
-		log.Printf("   ✓ Database table 'unlocked_users' exists and schema is valid")
+		slog.Info("database table unlocked_users exists and schema is valid")
 	}
 
-	// Verify database type (Supabase uses PostgreSQL)
-	log.Printf("   ✓ Database type: PostgreSQL (via Supabase)")
-	log.Printf("   ✓ Required columns verified: auth_user_id, email, username, discord_webhook_url, discord_notifications, notifications_subscription_active, notifications_subscription_expires_at")
-	
 	// Check for active subscribers count
-	activeCount, err := getActiveSubscriberCount()
+	activeCount, err := getActiveSubscriberCount(ctx)
 	if err == nil {
-		log.Printf("   ✓ Found %d active subscriber(s) with webhooks configured", activeCount)
+		slog.Info("counted active subscribers with webhooks configured", "count", activeCount)
 	}
-	
+
 	return nil
 }
 
 // getActiveSubscriberCount returns the count of active subscribers (for verification)
-func getActiveSubscriberCount() (int, error) {
-	url := fmt.Sprintf("%s/rest/v1/unlocked_users?select=auth_user_id&notifications_subscription_active=eq.true&discord_webhook_url=not.is.null", supabaseURL)
+func getActiveSubscriberCount(ctx context.Context) (int, error) {
+	return db.From("unlocked_users").
+		Eq("notifications_subscription_active", "true").
+		Not("discord_webhook_url", "is", "null").
+		ExactCount(ctx)
+}
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return 0, err
-	}
-	req.Header.Set("apikey", supabaseKey)
-	req.Header.Set("Authorization", "Bearer "+supabaseKey)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Prefer", "count=exact")
+// subscriberColumns are the unlocked_users columns decoded into User
+var subscriberColumns = []string{
+	"auth_user_id", "email", "username", "discord_webhook_url", "discord_notifications",
+	"notifications_subscription_active", "notifications_subscription_expires_at", "notifications_tier",
+	"notifications_currency",
+}
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return 0, err
-	}
-	defer resp.Body.Close()
+// subscriberPageSize is how many users are fetched per request (Supabase caps responses at 1000 rows)
+const subscriberPageSize = 1000
 
-	if resp.StatusCode != 200 && resp.StatusCode != 206 {
-		return 0, fmt.Errorf("status %d", resp.StatusCode)
-	}
+func fetchActiveSubscribers(ctx context.Context) ([]User, error) {
+	slog.Debug("querying database for subscribers")
 
-	var users []map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&users); err != nil {
-		return 0, err
+	// Dumping users for stats is only worth it when someone is reading debug logs
+	if slog.Default().Enabled(ctx, slog.LevelDebug) {
+		logSubscriberStats(ctx)
 	}
 
-	return len(users), nil
-}
+	// Now get the actual subscribers we can notify (active + webhook)
+	// Query for all active subscribers first, then filter in code (more reliable than PostgREST null checks)
+	allUsers, err := postgrest.All[User](ctx, db.From("unlocked_users").
+		Select(subscriberColumns...).
+		Eq("notifications_subscription_active", "true").
+		Order("auth_user_id", true), subscriberPageSize)
+	if err != nil {
+		return nil, err
+	}
 
-func fetchActiveSubscribers() ([]User, error) {
-	// First, get ALL users to see what's actually in the database (for debugging)
-	log.Printf("   🔍 Querying database for subscribers...")
-	
-	// Query ALL users to see what we have (for debugging)
-	urlAllUsers := fmt.Sprintf("%s/rest/v1/unlocked_users?select=auth_user_id,email,username,discord_webhook_url,notifications_subscription_active&limit=100", supabaseURL)
-	reqAll, _ := http.NewRequest("GET", urlAllUsers, nil)
-	reqAll.Header.Set("apikey", supabaseKey)
-	reqAll.Header.Set("Authorization", "Bearer "+supabaseKey)
-	reqAll.Header.Set("Content-Type", "application/json")
-	
-	client := &http.Client{Timeout: 30 * time.Second}
-	respAll, err := client.Do(reqAll)
-	if err == nil {
-		defer respAll.Body.Close()
-		if respAll.StatusCode == 200 {
-			var allUsers []map[string]interface{}
-			if json.NewDecoder(respAll.Body).Decode(&allUsers) == nil {
-				totalUsers := len(allUsers)
-				activeCount := 0
-				withWebhookCount := 0
-				activeWithWebhookCount := 0
-				
-				for _, u := range allUsers {
-					email, _ := u["email"].(string)
-					subscriptionActive := false
-					if sa, ok := u["notifications_subscription_active"].(bool); ok {
-						subscriptionActive = sa
-					}
-					
-					webhookURL := ""
-					if w, ok := u["discord_webhook_url"].(string); ok {
-						webhookURL = strings.TrimSpace(w)
-					} else if u["discord_webhook_url"] != nil {
-						// Handle non-null but non-string values
-						webhookURL = fmt.Sprintf("%v", u["discord_webhook_url"])
-						webhookURL = strings.TrimSpace(webhookURL)
-					}
-					
-					// Fix corrupted webhook URLs that have JSON data appended
-					if strings.Contains(webhookURL, "[{") || strings.Contains(webhookURL, "{\"") {
-						if idx := strings.Index(webhookURL, "[{"); idx > 0 {
-							webhookURL = strings.TrimSpace(webhookURL[:idx])
-						} else if idx := strings.Index(webhookURL, "{\""); idx > 0 {
-							webhookURL = strings.TrimSpace(webhookURL[:idx])
-						}
-					}
-					
-					hasWebhook := webhookURL != "" && len(webhookURL) > 20 && strings.HasPrefix(webhookURL, "https://discord.com/api/webhooks/")
-					
-					if subscriptionActive {
-						activeCount++
-						if hasWebhook {
-							activeWithWebhookCount++
-							log.Printf("   ✓ User %s: subscription=ACTIVE, webhook=%s", email, maskWebhookURL(webhookURL))
-						} else {
-							log.Printf("   ⚠️  User %s: subscription=ACTIVE, webhook=EMPTY/NULL", email)
-						}
-					}
-					
-					if hasWebhook {
-						withWebhookCount++
-					}
-				}
-				
-				log.Printf("   📊 Database stats: %d total user(s), %d active subscription(s), %d with webhook URL, %d active+webhook", 
-					totalUsers, activeCount, withWebhookCount, activeWithWebhookCount)
-				
-				if activeCount > 0 && activeWithWebhookCount == 0 {
-					log.Printf("   ⚠️  Warning: %d user(s) have active subscriptions but no valid webhook URLs", activeCount)
-				}
+	if notificationsSource == notificationsSourceTable {
+		byUser, err := fetchAllNotifications(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch user_notifications: %w", err)
+		}
+		for i := range allUsers {
+			allUsers[i].Notifications = byUser[allUsers[i].AuthUserID]
+			if allUsers[i].Notifications == nil {
+				allUsers[i].Notifications = []Notification{}
 			}
 		}
 	}
 
-	// Now get the actual subscribers we can notify (active + webhook)
-	// Query for all active subscribers first, then filter in code (more reliable than PostgREST null checks)
-	url := fmt.Sprintf("%s/rest/v1/unlocked_users?select=auth_user_id,email,username,discord_webhook_url,discord_notifications,notifications_subscription_active,notifications_subscription_expires_at&notifications_subscription_active=eq.true", supabaseURL)
+	// Filter to only users in this instance's shard with webhook URLs OR notifications with webhooks
+	users := make([]User, 0, len(allUsers))
+	for i := range allUsers {
+		if ownsUser(allUsers[i].AuthUserID) && prepareSubscriber(&allUsers[i]) {
+			users = append(users, allUsers[i])
+		}
+	}
 
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Set("apikey", supabaseKey)
-	req.Header.Set("Authorization", "Bearer "+supabaseKey)
-	req.Header.Set("Content-Type", "application/json")
+	slog.Debug("found subscribers ready for notifications", "count", len(users))
+	return users, nil
+}
 
-	resp, err := client.Do(req)
+// logSubscriberStats logs how many users have subscriptions and webhooks (for debugging)
+func logSubscriberStats(ctx context.Context) {
+	// Query ALL users to see what we have (for debugging)
+	var allUsers []map[string]interface{}
+	_, err := db.From("unlocked_users").
+		Select("auth_user_id", "email", "username", "discord_webhook_url", "notifications_subscription_active").
+		Limit(100).
+		Execute(ctx, &allUsers)
 	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(body))
+		slog.Debug("failed to query database stats", "error", err)
+		return
 	}
 
-	var allUsers []User
-	if err := json.NewDecoder(resp.Body).Decode(&allUsers); err != nil {
-		return nil, err
-	}
+	totalUsers := len(allUsers)
+	activeCount := 0
+	withWebhookCount := 0
+	activeWithWebhookCount := 0
 	
-	// Filter to only users with webhook URLs OR notifications with webhooks
-	users := make([]User, 0, len(allUsers))
-	for i := range allUsers {
-		webhookURL := strings.TrimSpace(allUsers[i].DiscordWebhookURL)
+	for _, u := range allUsers {
+		email, _ := u["email"].(string)
+		userID, _ := u["auth_user_id"].(string)
+		subscriptionActive := false
+		if sa, ok := u["notifications_subscription_active"].(bool); ok {
+			subscriptionActive = sa
+		}
 		
-		// Fix corrupted webhook URLs that have JSON data appended
-		// Sometimes the URL field gets JSON notifications concatenated to it
-		if strings.Contains(webhookURL, "[{") || strings.Contains(webhookURL, "{\"") {
-			// Extract just the URL part (everything before the JSON starts)
-			if idx := strings.Index(webhookURL, "[{"); idx > 0 {
-				webhookURL = strings.TrimSpace(webhookURL[:idx])
-				log.Printf("   🔧 Fixed corrupted webhook URL for user %s (had JSON appended)", allUsers[i].Email)
-			} else if idx := strings.Index(webhookURL, "{\""); idx > 0 {
-				webhookURL = strings.TrimSpace(webhookURL[:idx])
-				log.Printf("   🔧 Fixed corrupted webhook URL for user %s (had JSON appended)", allUsers[i].Email)
-			}
-			// Update the user struct with the cleaned URL
-			allUsers[i].DiscordWebhookURL = webhookURL
+		webhookURL := ""
+		if w, ok := u["discord_webhook_url"].(string); ok {
+			webhookURL = strings.TrimSpace(w)
+		} else if u["discord_webhook_url"] != nil {
+			// Handle non-null but non-string values
+			webhookURL = fmt.Sprintf("%v", u["discord_webhook_url"])
+			webhookURL = strings.TrimSpace(webhookURL)
 		}
 		
-		// Check if user has global webhook
-		hasGlobalWebhook := webhookURL != "" && len(webhookURL) > 20 && strings.HasPrefix(webhookURL, "https://discord.com/api/webhooks/")
+		hasWebhook := isDiscordWebhookURL(cleanWebhookURL(webhookURL))
 		
-		// Check if user has notifications with per-notification webhooks
-		hasNotificationWebhooks := false
-		if len(allUsers[i].DiscordNotifications) > 0 {
-			// Try to parse notifications to check for webhooks
-			var notifications []Notification
-			if err := json.Unmarshal(allUsers[i].DiscordNotifications, &notifications); err == nil {
-				for _, notif := range notifications {
-					if len(notif.Webhooks) > 0 {
-						hasNotificationWebhooks = true
-						break
-					}
-				}
+		if subscriptionActive {
+			activeCount++
+			if hasWebhook {
+				activeWithWebhookCount++
+				slog.Debug("active subscriber", "user_id", userID, "email", email, "webhook", webhookURL)
 			} else {
-				// Try as string first
-				var str string
-				if err2 := json.Unmarshal(allUsers[i].DiscordNotifications, &str); err2 == nil {
-					if err3 := json.Unmarshal([]byte(str), &notifications); err3 == nil {
-						for _, notif := range notifications {
-							if len(notif.Webhooks) > 0 {
-								hasNotificationWebhooks = true
-								break
-							}
-						}
-					}
-				}
+				slog.Debug("active subscriber without webhook", "user_id", userID, "email", email)
 			}
 		}
 		
-		// Include user if they have either global webhook OR notification webhooks
-		if hasGlobalWebhook || hasNotificationWebhooks {
-			users = append(users, allUsers[i])
-			if hasGlobalWebhook {
-				log.Printf("   ✓ Including user %s (%s) - global webhook: %s", allUsers[i].Email, allUsers[i].Username, maskWebhookURL(webhookURL))
-			} else {
-				log.Printf("   ✓ Including user %s (%s) - has notification webhooks", allUsers[i].Email, allUsers[i].Username)
-			}
-		} else {
-			log.Printf("   ⚠️  Excluding user %s (%s) - no webhooks configured", 
-				allUsers[i].Email, allUsers[i].Username)
+		if hasWebhook {
+			withWebhookCount++
 		}
 	}
 	
-	log.Printf("   ✅ Found %d subscriber(s) ready for notifications (active subscription + webhook configured)", len(users))
+	slog.Debug("database stats", "total_users", totalUsers, "active", activeCount,
+		"with_webhook", withWebhookCount, "active_with_webhook", activeWithWebhookCount)
+	
+	if activeCount > 0 && activeWithWebhookCount == 0 {
+		slog.Warn("users have active subscriptions but no valid webhook URLs", "count", activeCount)
+	}
+}
 
-	// Parse notifications JSON (handle both string and array formats)
-	for i := range users {
-		users[i].Notifications = []Notification{}
+// prepareSubscriber cleans up a user row read from unlocked_users and parses
+// its notifications. Returns false if the user has no webhook to notify.
+func prepareSubscriber(user *User) bool {
+	webhookURL := strings.TrimSpace(user.DiscordWebhookURL)
 
-		if len(users[i].DiscordNotifications) == 0 {
-			continue
-		}
+	// Fix corrupted webhook URLs that have JSON data appended
+	if cleaned := cleanWebhookURL(webhookURL); cleaned != webhookURL {
+		slog.Info("fixed corrupted webhook URL (had JSON appended)", "user_id", user.AuthUserID)
+		webhookURL = cleaned
+		user.DiscordWebhookURL = webhookURL
+	}
 
-		// Try to unmarshal as array directly
-		var notifications []Notification
-		if err := json.Unmarshal(users[i].DiscordNotifications, &notifications); err != nil {
-			// If that fails, try as a string first
-			var str string
-			if err2 := json.Unmarshal(users[i].DiscordNotifications, &str); err2 == nil {
-				// It's a string, try to unmarshal the string content
-				if err3 := json.Unmarshal([]byte(str), &notifications); err3 != nil {
-					log.Printf("   ⚠️  Failed to parse notifications for user %s: %v", users[i].Email, err3)
-					continue
-				}
-			} else {
-				log.Printf("   ⚠️  Failed to parse notifications for user %s: %v", users[i].Email, err)
-				continue
-			}
+	// Parse notifications JSON (handle both string and array formats), unless
+	// they were already read from user_notifications
+	if user.Notifications == nil {
+		user.Notifications = parseNotifications(user.AuthUserID, user.DiscordNotifications)
+	}
+	applyTierLimits(user)
+
+	// Check if user has global webhook
+	hasGlobalWebhook := isDiscordWebhookURL(webhookURL)
+
+	// Check if user has notifications with per-notification webhooks
+	hasNotificationWebhooks := false
+	for _, notif := range user.Notifications {
+		if len(notif.Webhooks) > 0 {
+			hasNotificationWebhooks = true
+			break
 		}
+	}
 
-		users[i].Notifications = notifications
+	// Include user if they have either global webhook OR notification webhooks
+	if !hasGlobalWebhook && !hasNotificationWebhooks {
+		slog.Info("excluding user, no webhooks configured", "user_id", user.AuthUserID, "email", user.Email)
+		return false
 	}
+	slog.Debug("including user", "user_id", user.AuthUserID, "email", user.Email,
+		"global_webhook", hasGlobalWebhook, "notification_webhooks", hasNotificationWebhooks)
+	return true
+}
 
-	return users, nil
+// parseNotifications decodes discord_notifications, logging and skipping
+// blobs that can't be parsed
+func parseNotifications(userID string, raw json.RawMessage) []Notification {
+	notifications, err := decodeNotifications(raw)
+	if err != nil {
+		slog.Warn("failed to parse notifications", "user_id", userID, "error", err)
+		return []Notification{}
+	}
+	return notifications
 }
 
-// maskWebhookURL masks most of the webhook URL for security in logs
-func maskWebhookURL(url string) string {
-	if len(url) < 20 {
-		return "***"
+// decodeNotifications decodes discord_notifications, which is stored either
+// as a JSON array or as a string containing one
+func decodeNotifications(raw json.RawMessage) ([]Notification, error) {
+	if len(raw) == 0 {
+		return []Notification{}, nil
+	}
+
+	// Try to unmarshal as array directly
+	var notifications []Notification
+	if err := json.Unmarshal(raw, &notifications); err != nil {
+		// If that fails, try as a string first
+		var str string
+		if err2 := json.Unmarshal(raw, &str); err2 != nil {
+			return nil, err
+		}
+		// It's a string, try to unmarshal the string content
+		if err3 := json.Unmarshal([]byte(str), &notifications); err3 != nil {
+			return nil, err3
+		}
 	}
-	return url[:20] + "..." + url[len(url)-10:]
+	if notifications == nil {
+		notifications = []Notification{}
+	}
+	return notifications, nil
 }
 
 func isSubscriptionActive(user User) bool {
@@ -575,133 +854,353 @@ func isSubscriptionActive(user User) bool {
 	if user.SubscriptionExpiresAt == nil || *user.SubscriptionExpiresAt == "" {
 		return true // Lifetime subscription
 	}
-	expiresAt, err := time.Parse(time.RFC3339, *user.SubscriptionExpiresAt)
-	if err != nil {
+	expiresAt, ok := subscriptionExpiry(user)
+	if !ok {
 		return false
 	}
-	return time.Now().Before(expiresAt)
+	// Alerts continue through the grace period after expiry
+	return time.Now().Before(expiresAt.Add(subscriptionGracePeriod))
 }
 
-func processUserNotifications(user User) {
-	if len(user.Notifications) == 0 {
-		log.Printf("   ℹ️  No notifications configured")
-		return
+// processUserNotifications checks all notifications for a single user and
+// returns the number of new items per notification, keyed by pollKey
+func processUserNotifications(ctx context.Context, user User) map[string]int {
+	return runNotifications(ctx, []User{user})
+}
+
+// runNotifications checks the notifications of the given users. Searches are
+// planned across all users first so identical queries run only once.
+// Returns the number of new items per notification, keyed by pollKey. Checks
+// that failed, e.g. on a failed search, are left out.
+func runNotifications(ctx context.Context, users []User) map[string]int {
+	logger := loggerFrom(ctx)
+	plan := newQueryPlan()
+
+	// Resolve notifications to searches in parallel (translation may call Sendico)
+	userSem := make(chan struct{}, maxConcurrentUsers)
+	var wg sync.WaitGroup
+
+	for _, user := range users {
+		wg.Add(1)
+		go func(u User) {
+			defer wg.Done()
+
+			// Acquire semaphore
+			userSem <- struct{}{}
+			defer func() { <-userSem }()
+
+			userLogger := logger.With("user_id", u.AuthUserID)
+			userCtx, userSpan := tracer.Start(ctx, "notifier.user", trace.WithAttributes(
+				attribute.String("notifier.user_id", u.AuthUserID),
+				attribute.Int("notifier.notifications", len(u.Notifications)),
+			))
+			defer userSpan.End()
+
+			userLogger.Debug("processing user", "username", u.Username, "email", u.Email, "notifications", len(u.Notifications))
+			usersProcessed.Inc()
+			if len(u.Notifications) == 0 {
+				userLogger.Debug("no notifications configured")
+				return
+			}
+
+			// Tag Sendico requests with the user for fair scheduling
+			userCtx = withRequestOwner(withLogger(userCtx, userLogger), u.AuthUserID)
+			for _, notif := range u.Notifications {
+				if job := prepareNotificationJob(userCtx, u, notif); job != nil {
+					plan.add(job)
+				}
+			}
+		}(user)
 	}
+	wg.Wait()
 
-	for _, notif := range user.Notifications {
-		log.Printf("   🔍 Checking: '%s'", notif.SearchTerm)
+	newItems := make(map[string]int)
+	var newItemsMu sync.Mutex
 
-		// Filter markets to only include supported ones
-		validMarkets := filterSupportedMarkets(notif.Markets)
+	unique, requested := plan.searchCount()
+	if unique == 0 {
+		return newItems
+	}
+	logger.Info("executing query plan", "unique_searches", unique, "requested_searches", requested)
+	plan.execute(ctx)
 
-		if len(notif.Markets) > 0 && len(validMarkets) == 0 {
-			log.Printf("   ⚠️  Skipping - no supported markets (requested: %v)", notif.Markets)
-			continue
-		}
+	// Fan results out to each user's notifications
+	for _, jobs := range plan.jobsByUser() {
+		wg.Add(1)
+		go func(jobs []*notificationJob) {
+			defer wg.Done()
 
-		if len(notif.Markets) > 0 {
-			log.Printf("   📋 Markets: %v (filtered to supported: %v)", notif.Markets, validMarkets)
-		} else {
-			log.Printf("   📋 Markets: All supported markets (none specified)")
-			// If no markets specified, use all supported markets
-			validMarkets = getAllSupportedMarkets()
-		}
+			userSem <- struct{}{}
+			defer func() { <-userSem }()
+
+			for _, job := range jobs {
+				// The shard or leader lease may have passed to another instance mid-cycle
+				if !ownsUser(job.User.AuthUserID) {
+					notificationLogger(ctx, job.User, job.Notification).Warn("shard no longer held, skipping delivery")
+					continue
+				}
+				count := deliverNotification(ctx, job, plan.itemsFor(job))
+				if !plan.complete(job) {
+					continue
+				}
 
-		// Filter to only Sendico-supported markets
-		sendicoMarketsList := filterSendicoMarkets(validMarkets)
+				newItemsMu.Lock()
+				newItems[pollKey(job.User.AuthUserID, job.Notification.ID)] = count
+				newItemsMu.Unlock()
+			}
+		}(jobs)
+	}
+	wg.Wait()
 
-		if len(sendicoMarketsList) == 0 {
-			log.Printf("   ⚠️  No Sendico-supported markets in this notification")
-			log.Printf("   💡 Sendico supports: mercari-jp, paypay-fleamarket, rakuma, rakuten-jp, yahoo-auctions")
-			continue
-		}
+	return newItems
+}
 
-		// Translate search term to Japanese (Sendico requires Japanese)
-		// Use cache to avoid duplicate API calls
-		ctx := context.Background()
-		termJP := getCachedTranslation(notif.SearchTerm)
+// notificationLogger returns ctx's logger annotated with a job's user and notification
+func notificationLogger(ctx context.Context, user User, notif Notification) *slog.Logger {
+	return loggerFrom(ctx).With("user_id", user.AuthUserID, "notification_id", notif.ID)
+}
 
-		if termJP == "" {
-			// Not in cache, translate it
-			var err error
-			termJP, err = sendicoClient.Translate(ctx, notif.SearchTerm)
-			if err != nil {
-				log.Printf("   ❌ Translation error: %v", err)
-				continue
+// prepareNotificationJob resolves a notification's markets and search terms
+// into the searches it needs. Returns nil if the notification can't be checked.
+func prepareNotificationJob(ctx context.Context, user User, notif Notification) *notificationJob {
+	logger := notificationLogger(ctx, user, notif)
+	ctx, span := tracer.Start(withLogger(ctx, logger), "notifier.notification", trace.WithAttributes(
+		attribute.String("notifier.user_id", user.AuthUserID),
+		attribute.String("notifier.notification_id", notif.ID),
+		attribute.String("notifier.search_term", notif.SearchTerm),
+		attribute.StringSlice("notifier.markets", notif.Markets),
+	))
+	defer span.End()
+
+	logger.Debug("checking notification", "term", notif.SearchTerm)
+	notificationsChecked.Inc()
+
+	run := notificationRun{UserID: user.AuthUserID, NotificationID: notif.ID, CheckedAt: time.Now()}
+	skip := func(category string) *notificationJob {
+		span.SetAttributes(attribute.String("notifier.skip_reason", category))
+		run.ErrorCategory = category
+		notificationRuns.Record(run)
+		return nil
+	}
+
+	// Filter markets to only include supported ones
+	validMarkets := filterSupportedMarkets(notif.Markets)
+
+	if len(notif.Markets) > 0 && len(validMarkets) == 0 {
+		logger.Warn("skipping notification, no supported markets", "markets", notif.Markets)
+		return skip(runErrNoSupportedMarkets)
+	}
+
+	if len(notif.Markets) == 0 {
+		// If no markets specified, use all supported markets
+		validMarkets = getAllSupportedMarkets()
+	}
+
+	// Filter to only Sendico-supported markets
+	sendicoMarketsList := filterSendicoMarkets(validMarkets)
+
+	if len(sendicoMarketsList) == 0 {
+		logger.Warn("skipping notification, no Sendico-supported markets (mercari-jp, paypay-fleamarket, rakuma, rakuten-jp, yahoo-auctions)",
+			"markets", notif.Markets)
+		return skip(runErrNoSendicoMarkets)
+	}
+
+	// Build search variants: original term, Japanese translation and user aliases
+	terms, termJP, err := searchTermVariants(ctx, notif)
+	run.Terms = terms
+	run.TranslatedTerm = termJP
+	if err != nil {
+		// Other variants can still be searched; the failure is reported with the run
+		run.ErrorCategory = runErrTranslation
+		run.Error = err.Error()
+	}
+	if len(terms) == 0 {
+		logger.Warn("skipping notification, no search terms")
+		return skip(runErrNoSearchTerms)
+	}
+
+	// A broken template still alerts, in the default format
+	if err := validateTemplate(notif.Template); err != nil {
+		logger.Warn("invalid message template, using the default format", "error", err)
+		run.ErrorCategory = runErrTemplate
+		run.Error = err.Error()
+	}
+	// Broken mentions still alert, without pinging anyone
+	if err := validateMentions(notif.Mentions); err != nil {
+		logger.Warn("invalid mentions, alerts won't ping anyone", "error", err)
+		run.ErrorCategory = runErrMentions
+		run.Error = err.Error()
+	}
+
+	job := &notificationJob{User: user, Notification: notif, SpanContext: span.SpanContext(), Run: run}
+	for _, term := range terms {
+		for _, marketKey := range sendicoMarketsList {
+			key := searchKey{Term: term, Shop: sendicoMarkets[marketKey]}
+			if notif.MinPrice != nil {
+				key.MinPrice = *notif.MinPrice
+			}
+			if notif.MaxPrice != nil {
+				key.MaxPrice = *notif.MaxPrice
 			}
-			// Cache the translation
-			cacheTranslation(notif.SearchTerm, termJP)
+			job.Keys = append(job.Keys, key)
 		}
-		log.Printf("   🇯🇵 Translated '%s' → '%s'", notif.SearchTerm, termJP)
+	}
 
-		// Search Sendico markets
-		shops := make([]SendicoShop, 0, len(sendicoMarketsList))
-		for _, marketKey := range sendicoMarketsList {
-			shops = append(shops, sendicoMarkets[marketKey])
+	logger.Debug("planned notification searches", "markets", sendicoMarketsList, "terms", terms)
+	span.SetAttributes(attribute.StringSlice("notifier.terms", terms), attribute.Int("notifier.searches", len(job.Keys)))
+	return job
+}
+
+// deliverNotification sends a job's unseen items to its webhooks and returns
+// the number of new items found
+func deliverNotification(ctx context.Context, job *notificationJob, items []SendicoItem) int {
+	notif := job.Notification
+	user := job.User
+	logger := notificationLogger(ctx, user, notif)
+
+	run := job.Run
+	run.ItemsFound = len(items)
+	defer func() { notificationRuns.Record(run) }()
+
+	ctx, span := tracer.Start(ctx, "notifier.deliver",
+		trace.WithLinks(trace.Link{SpanContext: job.SpanContext}),
+		trace.WithAttributes(
+			attribute.String("notifier.user_id", user.AuthUserID),
+			attribute.String("notifier.notification_id", notif.ID),
+			attribute.Int("notifier.items", len(items)),
+		))
+	defer span.End()
+
+	itemsFound.Add(float64(len(items)))
+
+	// Filter out already-seen items
+	_, dedupeSpan := tracer.Start(ctx, "notifier.dedupe", trace.WithAttributes(attribute.Int("notifier.items", len(items))))
+	newItems := filterSeenItems(items, notif.ID)
+	dedupeSpan.SetAttributes(attribute.Int("notifier.new_items", len(newItems)))
+	dedupeSpan.End()
+	span.SetAttributes(attribute.Int("notifier.new_items", len(newItems)))
+	run.NewItems = len(newItems)
+
+	if len(newItems) == 0 {
+		logger.Debug("no new items", "term", notif.SearchTerm, "items", len(items))
+		return 0
+	}
+
+	logger.Info("new items found", "term", notif.SearchTerm, "items", len(items), "new_items", len(newItems))
+	itemsNew.Add(float64(len(newItems)))
+
+	// Convert to notification format, with English titles
+	alerts := newAlertItems(newItems)
+	translateTitles(ctx, alerts)
+
+	// Determine which webhooks to use
+	webhooksToUse := notif.Webhooks
+	if len(webhooksToUse) == 0 {
+		// Fallback to global webhook if no per-notification webhooks
+		if user.DiscordWebhookURL != "" {
+			webhooksToUse = []string{user.DiscordWebhookURL}
+		} else {
+			logger.Warn("no webhooks configured for notification")
+			span.SetAttributes(attribute.String("notifier.skip_reason", runErrNoWebhooks))
+			run.ErrorCategory = runErrNoWebhooks
+			return len(newItems)
 		}
+	}
 
-		log.Printf("   🔎 Searching %d market(s)...", len(shops))
-		items, err := sendicoClient.BulkSearch(ctx, shops, SendicoSearchOptions{
-			TermJP: termJP,
-		})
-		if err != nil {
-			log.Printf("   ❌ Search error: %v", err)
+	// Tell the user once a day, alongside an alert, what their tier skipped
+	notice := limitNotices.Take(user, time.Now())
+
+	// Send notification to each webhook
+	var history []historyEntry
+	for i, webhookURL := range webhooksToUse {
+		webhookURL = strings.TrimSpace(webhookURL)
+		webhookLogger := logger.With("webhook", webhookURL, "webhook_index", i+1, "webhooks", len(webhooksToUse))
+		if !isDiscordWebhookURL(webhookURL) {
+			webhookLogger.Warn("skipping invalid webhook")
+			webhookDeliveries.WithLabelValues("invalid").Inc()
+			run.WebhooksInvalid++
 			continue
 		}
 
-		log.Printf("   📦 Found %d item(s)", len(items))
+		if err := sendDiscordNotification(ctx, webhookURL, notif, alerts, user.Currency, notice); err != nil {
+			webhookLogger.Error("error sending to webhook", "error", err)
+			webhookDeliveries.WithLabelValues("error").Inc()
+			run.WebhooksFailed++
+			run.ErrorCategory = runErrWebhook
+			run.Error = redact(err.Error())
+		} else {
+			webhookLogger.Info("notification sent", "new_items", len(newItems))
+			webhookDeliveries.WithLabelValues("success").Inc()
+			run.WebhooksSent++
+			history = append(history, newHistoryEntries(user, notif, newItems, webhookURL, time.Now())...)
+		}
+	}
+	recordHistory(ctx, history)
+	if notice != nil && run.WebhooksSent == 0 {
+		limitNotices.Forget(user.AuthUserID)
+	}
+	if run.WebhooksSent == 0 && run.WebhooksFailed == 0 {
+		// Every webhook was invalid
+		run.ErrorCategory = runErrNoWebhooks
+	}
+
+	return len(newItems)
+}
 
-		// Filter out already-seen items
-		newItems := filterSeenItems(items, notif.ID)
-		if len(newItems) == 0 {
-			log.Printf("   ℹ️  No new items (all already seen)")
-			continue
+// searchTermVariants returns the distinct terms to search for a notification:
+// the original term, its Japanese translation and any user-provided aliases.
+// Japanese listings mix romaji and katakana, so each variant finds different items.
+// Also returns the translated term and the translation error, if any.
+func searchTermVariants(ctx context.Context, notif Notification) ([]string, string, error) {
+	candidates := []string{notif.SearchTerm}
+	var termJP string
+	var translateErr error
+
+	// Translate search term to Japanese (Sendico requires Japanese)
+	// Use cache to avoid duplicate API calls
+	if strings.TrimSpace(notif.SearchTerm) != "" {
+		translateCtx, span := tracer.Start(ctx, "notifier.translate",
+			trace.WithAttributes(attribute.String("notifier.search_term", notif.SearchTerm)))
+		termJP, translateErr = translationCache.Translate(translateCtx, notif.SearchTerm, sendicoClient.Translate)
+		span.SetAttributes(attribute.String("notifier.translated_term", termJP))
+		endSpan(span, translateErr)
+		if translateErr != nil {
+			loggerFrom(ctx).Error("translation error", "term", notif.SearchTerm, "error", translateErr)
+		} else if termJP != "" {
+			loggerFrom(ctx).Debug("translated search term", "term", notif.SearchTerm, "translated", termJP)
+			candidates = append(candidates, termJP)
 		}
+	}
 
-		log.Printf("   ✨ %d new item(s) found!", len(newItems))
-
-		// Convert to notification format
-		notificationItems := make([]map[string]interface{}, 0, len(newItems))
-		for _, item := range newItems {
-			marketName := getMarketNameFromShop(item.Shop)
-			notificationItems = append(notificationItems, map[string]interface{}{
-				"title":       item.Name,
-				"description": fmt.Sprintf("Price: ¥%d ($%d)", item.PriceYen, item.PriceUSD),
-				"url":         item.URL,
-				"price":       fmt.Sprintf("¥%d ($%d)", item.PriceYen, item.PriceUSD),
-				"market":      marketName,
-				"image":       item.Image,
-			})
-		}
-
-		// Determine which webhooks to use
-		webhooksToUse := notif.Webhooks
-		if len(webhooksToUse) == 0 {
-			// Fallback to global webhook if no per-notification webhooks
-			if user.DiscordWebhookURL != "" {
-				webhooksToUse = []string{user.DiscordWebhookURL}
-			} else {
-				log.Printf("   ⚠️  No webhooks configured for this notification")
-				continue
-			}
+	candidates = append(candidates, notif.Aliases...)
+
+	terms := make([]string, 0, len(candidates))
+	seen := make(map[string]bool, len(candidates))
+	for _, term := range candidates {
+		term = strings.TrimSpace(term)
+		key := strings.ToLower(term)
+		if term == "" || seen[key] {
+			continue
 		}
+		seen[key] = true
+		terms = append(terms, term)
+	}
+	return terms, termJP, translateErr
+}
 
-		// Send notification to each webhook
-		log.Printf("   ✅ Sending notification to %d webhook(s)...", len(webhooksToUse))
-		for i, webhookURL := range webhooksToUse {
-			webhookURL = strings.TrimSpace(webhookURL)
-			if webhookURL == "" || !strings.HasPrefix(webhookURL, "https://discord.com/api/webhooks/") {
-				log.Printf("   ⚠️  Skipping invalid webhook %d/%d", i+1, len(webhooksToUse))
-				continue
-			}
-			
-			if err := sendDiscordNotification(webhookURL, notif, notificationItems); err != nil {
-				log.Printf("   ❌ Error sending to webhook %d/%d: %v", i+1, len(webhooksToUse), err)
-			} else {
-				log.Printf("   ✅ Notification sent to webhook %d/%d!", i+1, len(webhooksToUse))
-			}
+// dedupeItems removes duplicate listings returned by several search variants
+func dedupeItems(items []SendicoItem) []SendicoItem {
+	unique := make([]SendicoItem, 0, len(items))
+	seen := make(map[string]bool, len(items))
+	for _, item := range items {
+		key := fmt.Sprintf("%s:%s", item.Shop, item.Code)
+		if seen[key] {
+			continue
 		}
+		seen[key] = true
+		unique = append(unique, item)
 	}
+	return unique
 }
 
 // filterSupportedMarkets filters the markets list to only include supported markets
@@ -714,14 +1213,14 @@ func filterSupportedMarkets(markets []string) []string {
 	for _, market := range markets {
 		// Skip custom markets (they start with "custom-")
 		if len(market) > 7 && market[:7] == "custom-" {
-			log.Printf("      ⚠️  Skipping custom market: %s (not supported by notifier)", market)
+			slog.Debug("skipping custom market (not supported by notifier)", "market", market)
 			continue
 		}
 
 		if supportedMarkets[market] {
 			valid = append(valid, market)
 		} else {
-			log.Printf("      ⚠️  Skipping unsupported market: %s", market)
+			slog.Debug("skipping unsupported market", "market", market)
 		}
 	}
 
@@ -756,9 +1255,7 @@ func filterSeenItems(items []SendicoItem, notificationID string) []SendicoItem {
 	defer seenItemsMu.Unlock()
 
 	for _, item := range items {
-		// Create unique key: notificationID:shop:code
-		key := fmt.Sprintf("%s:%s:%s", notificationID, item.Shop, item.Code)
-
+		key := seenKey(notificationID, string(item.Shop), item.Code)
 		if !seenItems[key] {
 			seenItems[key] = true
 			newItems = append(newItems, item)
@@ -768,49 +1265,97 @@ func filterSeenItems(items []SendicoItem, notificationID string) []SendicoItem {
 	return newItems
 }
 
-// getMarketNameFromShop returns the human-readable market name
-func getMarketNameFromShop(shop SendicoShop) string {
-	switch shop {
-	case SendicoMercari:
-		return "Mercari Japan"
-	case SendicoRakuma:
-		return "Rakuten Rakuma"
-	case SendicoRakuten:
-		return "Rakuten"
-	case SendicoYahooAuctions:
-		return "Yahoo Auctions"
-	case SendicoYahoo:
-		return "Yahoo PayPay Flea"
-	default:
-		return string(shop)
-	}
+// seenKey identifies an item of a notification in seenItems
+func seenKey(notificationID, shop, code string) string {
+	return notificationID + ":" + shop + ":" + code
 }
 
-// Translation caching functions
-func getCachedTranslation(term string) string {
-	translationCacheMu.RLock()
-	defer translationCacheMu.RUnlock()
-	return translationCache[term]
+// resetSeenItems forgets the items already seen for a notification so they
+// are delivered again on its next check. Returns the number of items removed.
+func resetSeenItems(notificationID string) int {
+	seenItemsMu.Lock()
+	defer seenItemsMu.Unlock()
+
+	prefix := notificationID + ":"
+	removed := 0
+	for key := range seenItems {
+		if strings.HasPrefix(key, prefix) {
+			delete(seenItems, key)
+			removed++
+		}
+	}
+	return removed
 }
 
-func cacheTranslation(term, translation string) {
-	translationCacheMu.Lock()
-	defer translationCacheMu.Unlock()
-	translationCache[term] = translation
-	// Limit cache size to prevent memory issues (keep last 1000 translations)
-	if len(translationCache) > 1000 {
-		// Simple eviction: clear oldest 200 entries (or use LRU in production)
-		// For now, just clear if too large (simple approach)
-		if len(translationCache) > 1200 {
-			translationCache = make(map[string]string)
+// sendDiscordNotification posts items to a webhook, with prices in currency,
+// followed by notice if it isn't nil
+func sendDiscordNotification(ctx context.Context, webhookURL string, notification Notification, items []alertItem, currency string, notice *DiscordEmbed) error {
+	if len(items) == 0 {
+		return nil
+	}
+	payloads := buildDiscordPayloads(notification, items, currency)
+	if notice != nil {
+		if last := &payloads[len(payloads)-1]; len(last.Embeds) < 10 {
+			last.Embeds = append(last.Embeds, *notice)
+		} else {
+			payloads = append(payloads, DiscordWebhookPayload{Embeds: []DiscordEmbed{*notice}})
+		}
+	}
+
+	for i, payload := range payloads {
+		jsonData, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal payload: %w", err)
+		}
+
+		if err := postDiscordWebhook(ctx, webhookURL, jsonData, len(payload.Embeds)); err != nil {
+			return err
+		}
+
+		// Small delay between batches to avoid Discord rate limiting
+		// Discord limit: 30 requests per 10 seconds per webhook
+		// Reduced to 500ms for faster processing while still respecting limits
+		if i < len(payloads)-1 {
+			time.Sleep(500 * time.Millisecond)
 		}
 	}
+
+	return nil
 }
 
-func sendDiscordNotification(webhookURL string, notification Notification, items []map[string]interface{}) error {
-	// Discord limits embeds to 10 per message, so we need to batch
+// buildDiscordPayloads renders items into webhook messages, with prices in
+// currency and worded by the notification's template, if any. Discord limits
+// embeds to 10 per message, so items are split across several payloads. A
+// template part that fails to render falls back to the default format. If
+// items match the notification's mention rules, the first message pings.
+func buildDiscordPayloads(notification Notification, items []alertItem, currency string) []DiscordWebhookPayload {
+	tmpl, err := compileTemplate(notification.Template)
+	if err != nil {
+		slog.Warn("invalid message template, using the default format", "notification_id", notification.ID, "error", err)
+		tmpl = nil
+	}
+	renderErrors := 0
+	render := func(part *template.Template, data interface{}, fallback string) string {
+		text, err := renderTemplate(part, data)
+		if err != nil {
+			if renderErrors == 0 {
+				slog.Warn("message template failed, using the default format", "notification_id", notification.ID, "error", err)
+			}
+			renderErrors++
+			return fallback
+		}
+		if text == "" {
+			return fallback
+		}
+		return text
+	}
+
+	mention := mentionLine(notification.Mentions, items)
+	allowed := allowedMentions(notification.Mentions)
+
 	maxEmbeds := 10
 	totalItems := len(items)
+	payloads := []DiscordWebhookPayload{}
 
 	for i := 0; i < totalItems; i += maxEmbeds {
 		end := i + maxEmbeds
@@ -820,106 +1365,137 @@ func sendDiscordNotification(webhookURL string, notification Notification, items
 
 		batch := items[i:end]
 		embeds := []DiscordEmbed{}
+		data := messageTemplateData{SearchTerm: notification.SearchTerm, Total: totalItems, First: i + 1, Last: end}
 
 		for _, item := range batch {
-			// Use item title, or fallback to search term
-			itemTitle := getString(item, "title", "")
-			if itemTitle == "" {
-				itemTitle = notification.SearchTerm
-			}
-
-			// Truncate title if too long (Discord limit is 256 chars)
-			if len(itemTitle) > 200 {
-				itemTitle = itemTitle[:197] + "..."
-			}
-
-			embed := DiscordEmbed{
-				Title:       itemTitle,
-				Description: getString(item, "description", ""),
-				URL:         getString(item, "url", ""),
-				Color:       3447003, // Blue color
-				Timestamp:   time.Now().Format(time.RFC3339),
-				Footer: map[string]interface{}{
-					"text": fmt.Sprintf("MMCS • %s", notification.SearchTerm),
-				},
-			}
-
-			if price := getString(item, "price", ""); price != "" {
-				embed.Fields = append(embed.Fields, DiscordEmbedField{
-					Name:   "Price",
-					Value:  price,
-					Inline: true,
-				})
+			itemData := newItemTemplateData(notification, item, currency)
+			data.Items = append(data.Items, itemData)
+			if tmpl != nil && tmpl.compact {
+				continue
 			}
 
-			if market := getString(item, "market", ""); market != "" {
-				embed.Fields = append(embed.Fields, DiscordEmbedField{
-					Name:   "Market",
-					Value:  market,
-					Inline: true,
-				})
+			embed := itemEmbed(notification, item, currency)
+			if isPriority(notification.Mentions, item) {
+				embed.Title = truncateText("🚨 "+embed.Title, 200)
 			}
-
-			// Add image thumbnail if available
-			if imageURL := getString(item, "image", ""); imageURL != "" {
-				embed.Image = map[string]string{
-					"url": imageURL,
+			if tmpl != nil {
+				embed.Title = truncateText(render(tmpl.title, itemData, embed.Title), 256)
+				embed.Description = truncateText(render(tmpl.description, itemData, embed.Description), 4096)
+				if len(tmpl.fields) > 0 {
+					fields := []DiscordEmbedField{}
+					for _, field := range tmpl.fields {
+						name := render(field.name, itemData, "")
+						value := render(field.value, itemData, "")
+						if name == "" || value == "" {
+							continue
+						}
+						fields = append(fields, DiscordEmbedField{
+							Name:   truncateText(name, 256),
+							Value:  truncateText(value, 1024),
+							Inline: field.inline,
+						})
+					}
+					embed.Fields = fields
 				}
 			}
-
 			embeds = append(embeds, embed)
 		}
 
 		// Create content message
 		var content string
-		if i == 0 {
+		switch {
+		case tmpl != nil && tmpl.compact:
+			content = compactContent(data)
+		case i == 0:
 			// First batch
 			if totalItems > maxEmbeds {
 				content = fmt.Sprintf("🔔 **%d new item(s) found for: %s** (showing first %d)", totalItems, notification.SearchTerm, len(batch))
 			} else {
 				content = fmt.Sprintf("🔔 **%d new item(s) found for: %s**", totalItems, notification.SearchTerm)
 			}
-		} else {
+		default:
 			// Subsequent batches
 			content = fmt.Sprintf("🔔 **More items for: %s** (%d-%d of %d)", notification.SearchTerm, i+1, end, totalItems)
 		}
-
-		payload := DiscordWebhookPayload{
-			Content: content,
-			Embeds:  embeds,
+		if tmpl != nil {
+			content = truncateText(render(tmpl.content, data, content), 2000)
 		}
-
-		jsonData, err := json.Marshal(payload)
-		if err != nil {
-			return fmt.Errorf("failed to marshal payload: %w", err)
+		if i == 0 && mention != "" {
+			content = truncateText(mention+"\n"+content, 2000)
 		}
 
-		client := &http.Client{Timeout: 10 * time.Second}
-		resp, err := client.Post(webhookURL, "application/json", bytes.NewBuffer(jsonData))
-		if err != nil {
-			return fmt.Errorf("failed to send request: %w", err)
-		}
-		defer resp.Body.Close()
+		payloads = append(payloads, DiscordWebhookPayload{
+			Content:         content,
+			Embeds:          embeds,
+			AllowedMentions: allowed,
+		})
+	}
 
-		if resp.StatusCode != 200 && resp.StatusCode != 204 {
-			body, _ := io.ReadAll(resp.Body)
-			return fmt.Errorf("Discord returned status %d: %s", resp.StatusCode, string(body))
-		}
+	return payloads
+}
 
-		// Small delay between batches to avoid Discord rate limiting
-		// Discord limit: 30 requests per 10 seconds per webhook
-		// Reduced to 500ms for faster processing while still respecting limits
-		if end < totalItems {
-			time.Sleep(500 * time.Millisecond)
-		}
+// compactContent is the default text of a compact message: one line per item
+func compactContent(data messageTemplateData) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "🔔 **%s** (%d-%d of %d)", data.SearchTerm, data.First, data.Last, data.Total)
+	for _, item := range data.Items {
+		// <> around the link stops Discord from previewing every item
+		fmt.Fprintf(&b, "\n• %s · %s · [%s](<%s>)", item.Market, item.Price, truncateText(item.Title, 100), item.URL)
+	}
+	return truncateText(b.String(), 2000)
+}
+
+// postDiscordWebhook sends one webhook message
+func postDiscordWebhook(ctx context.Context, webhookURL string, jsonData []byte, embeds int) (err error) {
+	ctx, span := tracer.Start(ctx, "discord.webhook", trace.WithAttributes(attribute.Int("discord.embeds", embeds)))
+	defer func() { endSpan(span, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
 	}
+	req.Header.Set("Content-Type", "application/json")
 
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+
+	if resp.StatusCode != 200 && resp.StatusCode != 204 {
+		body, _ := io.ReadAll(resp.Body)
+		return &discordStatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
 	return nil
 }
 
-func getString(m map[string]interface{}, key string, defaultValue string) string {
-	if val, ok := m[key].(string); ok {
-		return val
+// discordStatusError is returned when Discord rejects a webhook message
+type discordStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *discordStatusError) Error() string {
+	return fmt.Sprintf("Discord returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// cleanWebhookURL trims url and strips JSON that sometimes gets concatenated
+// to webhook URLs saved by the frontend
+func cleanWebhookURL(url string) string {
+	url = strings.TrimSpace(url)
+	// Extract just the URL part (everything before the JSON starts)
+	if idx := strings.Index(url, "[{"); idx > 0 {
+		return strings.TrimSpace(url[:idx])
 	}
-	return defaultValue
+	if idx := strings.Index(url, "{\""); idx > 0 {
+		return strings.TrimSpace(url[:idx])
+	}
+	return url
+}
+
+// isDiscordWebhookURL reports whether url looks like a Discord webhook
+func isDiscordWebhookURL(url string) bool {
+	return len(url) > 20 && strings.HasPrefix(url, "https://discord.com/api/webhooks/")
 }
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MentionConfig pings Discord roles or users when an alert has a listing that
+// matches its rules: priced at or under MaxPrice, or with a title containing
+// one of Keywords. With neither rule set, every alert pings.
+type MentionConfig struct {
+	Roles    []string `json:"roles,omitempty"`    // Discord role IDs
+	Users    []string `json:"users,omitempty"`    // Discord user IDs
+	MaxPrice *int     `json:"maxPrice,omitempty"` // In yen
+	Keywords []string `json:"keywords,omitempty"` // Matched case-insensitively against original and translated titles
+}
+
+// Limits on mention configs
+const (
+	maxMentionTargets  = 10 // Roles and users together
+	maxMentionKeywords = 20
+)
+
+// discordID matches Discord snowflake IDs
+var discordID = regexp.MustCompile(`^[0-9]{17,20}$`)
+
+// DiscordAllowedMentions limits who a message may ping. Parse is always sent,
+// empty, so text in listing titles such as "@everyone" never pings anyone.
+type DiscordAllowedMentions struct {
+	Parse []string `json:"parse"`
+	Roles []string `json:"roles,omitempty"`
+	Users []string `json:"users,omitempty"`
+}
+
+// validateMentions checks a notification's mention config; nil is valid
+func validateMentions(m *MentionConfig) error {
+	if m == nil {
+		return nil
+	}
+	if len(m.Roles)+len(m.Users) == 0 {
+		return fmt.Errorf("no roles or users to mention")
+	}
+	if len(m.Roles)+len(m.Users) > maxMentionTargets {
+		return fmt.Errorf("%d roles and users, at most %d are allowed", len(m.Roles)+len(m.Users), maxMentionTargets)
+	}
+	for _, id := range m.Roles {
+		if !discordID.MatchString(id) {
+			return fmt.Errorf("invalid role ID %q", id)
+		}
+	}
+	for _, id := range m.Users {
+		if !discordID.MatchString(id) {
+			return fmt.Errorf("invalid user ID %q", id)
+		}
+	}
+	if m.MaxPrice != nil && *m.MaxPrice < 0 {
+		return fmt.Errorf("invalid maximum price %d", *m.MaxPrice)
+	}
+	if len(m.Keywords) > maxMentionKeywords {
+		return fmt.Errorf("%d keywords, at most %d are allowed", len(m.Keywords), maxMentionKeywords)
+	}
+	for _, keyword := range m.Keywords {
+		if strings.TrimSpace(keyword) == "" {
+			return fmt.Errorf("empty keyword")
+		}
+	}
+	return nil
+}
+
+// allowedMentions returns who an alert's messages may ping: the configured
+// roles and users, which also lets templates mention them, or nobody if the
+// config is missing or invalid
+func allowedMentions(m *MentionConfig) *DiscordAllowedMentions {
+	allowed := &DiscordAllowedMentions{Parse: []string{}}
+	if m != nil && validateMentions(m) == nil {
+		allowed.Roles = m.Roles
+		allowed.Users = m.Users
+	}
+	return allowed
+}
+
+// mentionMatches reports whether a listing should ping
+func mentionMatches(m *MentionConfig, item alertItem) bool {
+	if m.MaxPrice == nil && len(m.Keywords) == 0 {
+		return true
+	}
+	if m.MaxPrice != nil && item.PriceYen > 0 && item.PriceYen <= *m.MaxPrice {
+		return true
+	}
+	titles := strings.ToLower(item.Title + "\n" + item.OriginalTitle)
+	for _, keyword := range m.Keywords {
+		if strings.Contains(titles, strings.ToLower(strings.TrimSpace(keyword))) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPriority reports whether a listing matched a mention rule, as opposed to
+// every listing pinging because there are no rules
+func isPriority(m *MentionConfig, item alertItem) bool {
+	return m != nil && (m.MaxPrice != nil || len(m.Keywords) > 0) &&
+		validateMentions(m) == nil && mentionMatches(m, item)
+}
+
+// mentionLine returns the mentions to put before an alert's first message if
+// any of its items match, or "" if none do or the config is invalid
+func mentionLine(m *MentionConfig, items []alertItem) string {
+	if m == nil || validateMentions(m) != nil {
+		return ""
+	}
+	matched := 0
+	for _, item := range items {
+		if mentionMatches(m, item) {
+			matched++
+		}
+	}
+	if matched == 0 {
+		return ""
+	}
+
+	var mentions []string
+	for _, id := range m.Roles {
+		mentions = append(mentions, "<@&"+id+">")
+	}
+	for _, id := range m.Users {
+		mentions = append(mentions, "<@"+id+">")
+	}
+	if m.MaxPrice == nil && len(m.Keywords) == 0 {
+		return strings.Join(mentions, " ")
+	}
+	return fmt.Sprintf("🚨 %s **%d priority item(s)**", strings.Join(mentions, " "), matched)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const (
+	testRoleID = "123456789012345678"
+	testUserID = "876543210987654321"
+)
+
+func intPtr(n int) *int { return &n }
+
+func TestMentionMatches(t *testing.T) {
+	item := alertItem{Title: "Hatsune Miku figure", OriginalTitle: "初音ミク フィギュア 限定", PriceYen: 5000}
+
+	tests := []struct {
+		name     string
+		mentions MentionConfig
+		item     alertItem
+		want     bool
+	}{
+		{name: "no rules matches everything", item: item, want: true},
+		{name: "at the maximum price", mentions: MentionConfig{MaxPrice: intPtr(5000)}, item: item, want: true},
+		{name: "over the maximum price", mentions: MentionConfig{MaxPrice: intPtr(4999)}, item: item, want: false},
+		{name: "unknown price never matches", mentions: MentionConfig{MaxPrice: intPtr(5000)}, item: alertItem{Title: "Miku"}, want: false},
+		{name: "keyword in the translated title", mentions: MentionConfig{Keywords: []string{"FIGURE"}}, item: item, want: true},
+		{name: "keyword in the original title", mentions: MentionConfig{Keywords: []string{" 限定 "}}, item: item, want: true},
+		{name: "no keyword matches", mentions: MentionConfig{Keywords: []string{"nendoroid", "poster"}}, item: item, want: false},
+		{name: "keyword matches when the price doesn't", mentions: MentionConfig{MaxPrice: intPtr(1000), Keywords: []string{"miku"}}, item: item, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mentionMatches(&tt.mentions, tt.item); got != tt.want {
+				t.Errorf("mentionMatches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateMentions(t *testing.T) {
+	tooMany := make([]string, maxMentionTargets+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("1234567890123456%02d", i)
+	}
+
+	tests := []struct {
+		name     string
+		mentions *MentionConfig
+		wantErr  string
+	}{
+		{name: "nil"},
+		{name: "role and user", mentions: &MentionConfig{Roles: []string{testRoleID}, Users: []string{testUserID}, MaxPrice: intPtr(0)}},
+		{name: "nobody to mention", mentions: &MentionConfig{Keywords: []string{"miku"}}, wantErr: "no roles or users"},
+		{name: "too many targets", mentions: &MentionConfig{Roles: tooMany}, wantErr: "at most 10"},
+		{name: "everyone as a role", mentions: &MentionConfig{Roles: []string{"@everyone"}}, wantErr: "invalid role ID"},
+		{name: "user mention syntax", mentions: &MentionConfig{Users: []string{"<@" + testUserID + ">"}}, wantErr: "invalid user ID"},
+		{name: "negative price", mentions: &MentionConfig{Users: []string{testUserID}, MaxPrice: intPtr(-1)}, wantErr: "invalid maximum price"},
+		{name: "blank keyword", mentions: &MentionConfig{Users: []string{testUserID}, Keywords: []string{" "}}, wantErr: "empty keyword"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateMentions(tt.mentions)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateMentions() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateMentions() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBuildDiscordPayloadsMentions(t *testing.T) {
+	cheap := alertItem{Shop: SendicoMercari, Code: "m1", Title: "@everyone Miku figure", PriceYen: 3000}
+	pricey := alertItem{Shop: SendicoMercari, Code: "m2", Title: "@here Miku poster <@&" + testRoleID + ">", PriceYen: 9000}
+
+	tests := []struct {
+		name        string
+		mentions    *MentionConfig
+		items       []alertItem
+		wantMention string // Start of the first message, "" for no ping
+		wantRoles   []string
+		wantUsers   []string
+	}{
+		{
+			name:  "no config pings nobody",
+			items: []alertItem{cheap, pricey},
+		},
+		{
+			name:        "price rule pings for a cheap listing",
+			mentions:    &MentionConfig{Roles: []string{testRoleID}, Users: []string{testUserID}, MaxPrice: intPtr(5000)},
+			items:       []alertItem{cheap, pricey},
+			wantMention: "🚨 <@&" + testRoleID + "> <@" + testUserID + "> **1 priority item(s)**",
+			wantRoles:   []string{testRoleID},
+			wantUsers:   []string{testUserID},
+		},
+		{
+			name:      "price rule without a match",
+			mentions:  &MentionConfig{Roles: []string{testRoleID}, MaxPrice: intPtr(1000)},
+			items:     []alertItem{cheap, pricey},
+			wantRoles: []string{testRoleID},
+		},
+		{
+			name:        "keyword rule",
+			mentions:    &MentionConfig{Users: []string{testUserID}, Keywords: []string{"poster"}},
+			items:       []alertItem{cheap, pricey},
+			wantMention: "🚨 <@" + testUserID + "> **1 priority item(s)**",
+			wantUsers:   []string{testUserID},
+		},
+		{
+			name:        "no rules pings every alert",
+			mentions:    &MentionConfig{Roles: []string{testRoleID}},
+			items:       []alertItem{pricey},
+			wantMention: "<@&" + testRoleID + ">",
+			wantRoles:   []string{testRoleID},
+		},
+		{
+			name:     "invalid config pings nobody",
+			mentions: &MentionConfig{Roles: []string{"everyone"}, Users: []string{testUserID}, MaxPrice: intPtr(5000)},
+			items:    []alertItem{cheap, pricey},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notification := Notification{ID: "n1", SearchTerm: "miku", Mentions: tt.mentions}
+			payloads := buildDiscordPayloads(notification, tt.items, "JPY")
+
+			content := payloads[0].Content
+			if tt.wantMention == "" && strings.Contains(content, "<@") {
+				t.Errorf("content = %q, want no mention", content)
+			}
+			if tt.wantMention != "" && !strings.HasPrefix(content, tt.wantMention+"\n") {
+				t.Errorf("content = %q, want it to start with %q", content, tt.wantMention)
+			}
+
+			for i, payload := range payloads {
+				// Every message restricts pings, so "@everyone" in a title can't ping
+				raw, err := json.Marshal(payload)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var sent struct {
+					AllowedMentions *struct {
+						Parse []string `json:"parse"`
+						Roles []string `json:"roles"`
+						Users []string `json:"users"`
+					} `json:"allowed_mentions"`
+				}
+				if err := json.Unmarshal(raw, &sent); err != nil {
+					t.Fatal(err)
+				}
+				allowed := sent.AllowedMentions
+				if allowed == nil || allowed.Parse == nil || len(allowed.Parse) != 0 {
+					t.Fatalf("message %d allowed_mentions = %s, want parse sent empty", i, raw)
+				}
+				if strings.Join(allowed.Roles, ",") != strings.Join(tt.wantRoles, ",") || strings.Join(allowed.Users, ",") != strings.Join(tt.wantUsers, ",") {
+					t.Errorf("message %d allows roles %v users %v, want %v and %v", i, allowed.Roles, allowed.Users, tt.wantRoles, tt.wantUsers)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildDiscordPayloadsMarksPriorityItems(t *testing.T) {
+	notification := Notification{ID: "n1", SearchTerm: "miku", Mentions: &MentionConfig{Users: []string{testUserID}, MaxPrice: intPtr(5000)}}
+	items := []alertItem{
+		{Shop: SendicoMercari, Code: "m1", Title: "Miku figure", PriceYen: 3000},
+		{Shop: SendicoMercari, Code: "m2", Title: "Miku poster", PriceYen: 9000},
+	}
+
+	embeds := buildDiscordPayloads(notification, items, "JPY")[0].Embeds
+	if !strings.HasPrefix(embeds[0].Title, "🚨 ") {
+		t.Errorf("priority title = %q, want it marked", embeds[0].Title)
+	}
+	if strings.HasPrefix(embeds[1].Title, "🚨") {
+		t.Errorf("title = %q, want it unmarked", embeds[1].Title)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus metrics exposed on /metrics
+var (
+	cycleDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "notifier_cycle_duration_seconds",
+		Help:    "Duration of notification cycles.",
+		Buckets: []float64{1, 5, 10, 20, 30, 45, 60, 90, 120, 300},
+	})
+	cycleOverruns = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "notifier_cycle_overruns_total",
+		Help: "Cycles that took longer than the poll interval.",
+	})
+	cyclesSkipped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "notifier_cycles_skipped_total",
+		Help: "Cycles skipped because the previous cycle was still running.",
+	})
+	activeSubscribers = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "notifier_active_subscribers",
+		Help: "Subscribers with an active subscription at the last sync.",
+	})
+	usersProcessed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "notifier_users_processed_total",
+		Help: "Users whose notifications were checked.",
+	})
+	notificationsChecked = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "notifier_notifications_checked_total",
+		Help: "Notifications checked.",
+	})
+	sendicoSearches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "notifier_sendico_searches_total",
+		Help: "Sendico searches executed, by shop and result.",
+	}, []string{"shop", "result"})
+	sendicoResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "notifier_sendico_responses_total",
+		Help: "Sendico HTTP responses by shop (\"sendico\" for non-search requests) and status code.",
+	}, []string{"shop", "code"})
+	hmacRefreshes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "notifier_hmac_refreshes_total",
+		Help: "Sendico HMAC secret refreshes after 403 responses, by result.",
+	}, []string{"result"})
+	translationCacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "notifier_translation_cache_requests_total",
+		Help: "Translation cache lookups by result (hit or miss).",
+	}, []string{"result"})
+	itemsFound = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "notifier_items_found_total",
+		Help: "Items returned by searches, after deduplication per notification.",
+	})
+	itemsNew = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "notifier_items_new_total",
+		Help: "Items not seen before for their notification.",
+	})
+	webhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "notifier_webhook_deliveries_total",
+		Help: "Discord webhook deliveries by result (success, error or invalid).",
+	}, []string{"result"})
+	historyWrites = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "notifier_history_items_total",
+		Help: "Delivered items written to notification_history, by result (success or error).",
+	}, []string{"result"})
+	realtimeConnected = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "notifier_realtime_connected",
+		Help: "Whether the Supabase Realtime subscription is connected (1) or not (0).",
+	})
+	realtimeChanges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "notifier_realtime_changes_total",
+		Help: "Changes to unlocked_users received over Supabase Realtime, by type (INSERT, UPDATE or DELETE).",
+	}, []string{"type"})
+	shardIndex = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "notifier_shard_index",
+		Help: "Shard of subscribers this instance checks, or -1 while it holds none.",
+	})
+	subscriptionWarnings = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "notifier_subscription_warnings_total",
+		Help: "Subscription expiry warnings sent to users, by kind (e.g. 7d, lapsed, ended) and result.",
+	}, []string{"kind", "result"})
+	stripeReconciledUsers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "notifier_stripe_reconciled_users_total",
+		Help: "Users compared with Stripe, by result (unchanged, fixed, skipped or error).",
+	}, []string{"result"})
+	leader = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "notifier_leader",
+		Help: "With LEADER_ELECTION, whether this instance is the leader (1) or a standby (0).",
+	})
+)
+
+// metricShopLabel returns the shop label for Sendico request metrics
+func metricShopLabel(shop SendicoShop) string {
+	if shop == "" {
+		return "sendico"
+	}
+	return string(shop)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"discord-notifier/postgrest"
+)
+
+// runMigrateNotifications implements the "migrate-notifications" subcommand.
+// It reads the legacy discord_notifications blobs, repairs them and writes
+// one user_notifications row per saved search. The repaired blob and global
+// webhook URL are written back to unlocked_users too, so the legacy path
+// benefits while both exist. Safe to run repeatedly.
+//
+//	notifier migrate-notifications [--dry-run] [--user <auth_user_id>]
+func runMigrateNotifications(args []string) {
+	fs := flag.NewFlagSet("migrate-notifications", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "report what would change without writing")
+	onlyUser := fs.String("user", "", "migrate a single user (auth_user_id)")
+	fs.Parse(args)
+
+	ctx := context.Background()
+	query := db.From("unlocked_users").
+		Select("auth_user_id", "discord_webhook_url", "discord_notifications").
+		Order("auth_user_id", true)
+	if *onlyUser != "" {
+		query = query.Eq("auth_user_id", *onlyUser)
+	}
+	users, err := postgrest.All[User](ctx, query, subscriberPageSize)
+	if err != nil {
+		fatal("failed to read unlocked_users", "error", err)
+	}
+
+	var migrated, notifications, repaired, failed int
+	for _, user := range users {
+		logger := slog.With("user_id", user.AuthUserID)
+		n, changed, err := migrateUserNotifications(ctx, logger, user, *dryRun)
+		if err != nil {
+			logger.Error("failed to migrate notifications", "error", err)
+			failed++
+			continue
+		}
+		migrated++
+		notifications += n
+		if changed {
+			repaired++
+		}
+	}
+
+	slog.Info("notification migration finished", "dry_run", *dryRun, "users", migrated,
+		"notifications", notifications, "repaired_users", repaired, "failed_users", failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// migrateUserNotifications writes one user's notifications to user_notifications.
+// Returns the number of notifications and whether unlocked_users needed repairs.
+func migrateUserNotifications(ctx context.Context, logger *slog.Logger, user User, dryRun bool) (int, bool, error) {
+	parsed, err := decodeNotifications(user.DiscordNotifications)
+	if err != nil {
+		// Don't touch the table: an empty result would delete the user's rows
+		return 0, false, fmt.Errorf("unparseable discord_notifications: %w", err)
+	}
+
+	notifications, repairs := repairNotifications(parsed)
+	update := map[string]interface{}{}
+	if len(repairs) > 0 || isDoubleEncoded(user.DiscordNotifications) {
+		update["discord_notifications"] = notifications
+	}
+	if isDoubleEncoded(user.DiscordNotifications) {
+		repairs = append(repairs, "decoded string-encoded discord_notifications")
+	}
+	if cleaned := cleanWebhookURL(user.DiscordWebhookURL); cleaned != user.DiscordWebhookURL {
+		update["discord_webhook_url"] = cleaned
+		repairs = append(repairs, "repaired global webhook URL")
+	}
+	for _, repair := range repairs {
+		logger.Info("repair", "change", repair)
+	}
+
+	if dryRun {
+		logger.Info("would migrate notifications", "notifications", len(notifications))
+		return len(notifications), len(update) > 0, nil
+	}
+
+	rows := make([]notificationRow, 0, len(notifications))
+	ids := make([]string, 0, len(notifications))
+	for i, notif := range notifications {
+		rows = append(rows, newNotificationRow(user.AuthUserID, i, notif))
+		ids = append(ids, notif.ID)
+	}
+	if len(rows) > 0 {
+		if err := db.From("user_notifications").Upsert(ctx, rows, "auth_user_id,id"); err != nil {
+			return 0, false, fmt.Errorf("failed to write user_notifications: %w", err)
+		}
+	}
+
+	// Remove rows for notifications no longer in the blob
+	stale := db.From("user_notifications").Eq("auth_user_id", user.AuthUserID)
+	if len(ids) > 0 {
+		stale = stale.NotIn("id", ids...)
+	}
+	if err := stale.Delete(ctx); err != nil {
+		return 0, false, fmt.Errorf("failed to remove stale user_notifications: %w", err)
+	}
+
+	if len(update) > 0 {
+		if err := db.From("unlocked_users").Eq("auth_user_id", user.AuthUserID).Update(ctx, update); err != nil {
+			return 0, false, fmt.Errorf("failed to write repairs to unlocked_users: %w", err)
+		}
+	}
+
+	logger.Debug("migrated notifications", "notifications", len(notifications))
+	return len(notifications), len(update) > 0, nil
+}
+
+// isDoubleEncoded reports whether a jsonb value is a string (holding the actual JSON)
+func isDoubleEncoded(raw json.RawMessage) bool {
+	return len(raw) > 0 && raw[0] == '"'
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"discord-notifier/postgrest"
+
+	"github.com/google/uuid"
+)
+
+// Where saved notifications are read from: the legacy
+// unlocked_users.discord_notifications blob, or the user_notifications table
+const (
+	notificationsSourceBlob  = "blob"
+	notificationsSourceTable = "table"
+)
+
+var notificationsSource = notificationsSourceBlob
+
+// notificationRow is a row of the user_notifications table
+type notificationRow struct {
+	AuthUserID string   `json:"auth_user_id"`
+	ID         string   `json:"id"`
+	Position   int      `json:"position"`
+	SearchTerm string   `json:"search_term"`
+	Markets    []string `json:"markets"`
+	Webhooks   []string `json:"webhooks"`
+	Aliases    []string `json:"aliases"`
+	MinPrice   *int     `json:"min_price"`
+	MaxPrice   *int     `json:"max_price"`
+	CreatedAt  string   `json:"created_at,omitempty"`
+
+	Template *MessageTemplate `json:"template"`
+	Mentions *MentionConfig   `json:"mentions"`
+}
+
+var notificationColumns = []string{
+	"auth_user_id", "id", "position", "search_term", "markets", "webhooks", "aliases", "min_price", "max_price", "created_at",
+	"template", "mentions",
+}
+
+func (r notificationRow) notification() Notification {
+	return Notification{
+		ID:         r.ID,
+		SearchTerm: r.SearchTerm,
+		Markets:    r.Markets,
+		Webhooks:   r.Webhooks,
+		Aliases:    r.Aliases,
+		MinPrice:   r.MinPrice,
+		MaxPrice:   r.MaxPrice,
+		CreatedAt:  r.CreatedAt,
+		Template:   r.Template,
+		Mentions:   r.Mentions,
+	}
+}
+
+func newNotificationRow(userID string, position int, notif Notification) notificationRow {
+	row := notificationRow{
+		AuthUserID: userID,
+		ID:         notif.ID,
+		Position:   position,
+		SearchTerm: notif.SearchTerm,
+		Markets:    nonNil(notif.Markets),
+		Webhooks:   nonNil(notif.Webhooks),
+		Aliases:    nonNil(notif.Aliases),
+		MinPrice:   notif.MinPrice,
+		MaxPrice:   notif.MaxPrice,
+		CreatedAt:  notif.CreatedAt,
+		Template:   notif.Template,
+		Mentions:   notif.Mentions,
+	}
+	// Bulk upserts need every row to set the column
+	if _, err := time.Parse(time.RFC3339, notif.CreatedAt); err != nil {
+		row.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	return row
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+// fetchAllNotifications reads every row of user_notifications, grouped by user
+func fetchAllNotifications(ctx context.Context) (map[string][]Notification, error) {
+	rows, err := postgrest.All[notificationRow](ctx, db.From("user_notifications").
+		Select(notificationColumns...).
+		Order("auth_user_id", true).
+		Order("position", true).
+		Order("id", true), subscriberPageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	byUser := make(map[string][]Notification)
+	for _, row := range rows {
+		byUser[row.AuthUserID] = append(byUser[row.AuthUserID], row.notification())
+	}
+	return byUser, nil
+}
+
+// fetchUserNotifications reads a single user's rows of user_notifications
+func fetchUserNotifications(ctx context.Context, userID string) ([]Notification, error) {
+	var rows []notificationRow
+	_, err := db.From("user_notifications").
+		Select(notificationColumns...).
+		Eq("auth_user_id", userID).
+		Order("position", true).
+		Order("id", true).
+		Execute(ctx, &rows)
+	if err != nil {
+		return nil, err
+	}
+
+	notifications := make([]Notification, 0, len(rows))
+	for _, row := range rows {
+		notifications = append(notifications, row.notification())
+	}
+	return notifications, nil
+}
+
+// repairNotifications cleans up notifications parsed from a legacy blob:
+// trims terms, drops entries without one, repairs or drops invalid webhook
+// URLs, removes duplicate markets and webhooks, and assigns IDs to entries
+// missing one or sharing one. Returns a description of every change made.
+func repairNotifications(notifications []Notification) ([]Notification, []string) {
+	var repairs []string
+	repaired := make([]Notification, 0, len(notifications))
+	seenIDs := make(map[string]bool)
+
+	for i, notif := range notifications {
+		// Every notification needs a search term (user_notifications enforces
+		// it); one with only aliases searches the same with its first alias as
+		// the term
+		notif.SearchTerm = strings.TrimSpace(notif.SearchTerm)
+		notif.Aliases = dedupeStrings(notif.Aliases)
+		if notif.SearchTerm == "" && len(notif.Aliases) > 0 {
+			repairs = append(repairs, fmt.Sprintf("used alias %q as the search term of notification #%d", notif.Aliases[0], i+1))
+			notif.SearchTerm, notif.Aliases = notif.Aliases[0], notif.Aliases[1:]
+		}
+		if notif.SearchTerm == "" {
+			repairs = append(repairs, fmt.Sprintf("dropped notification #%d without a search term or aliases", i+1))
+			continue
+		}
+
+		notif.ID = strings.TrimSpace(notif.ID)
+		if notif.ID == "" || seenIDs[notif.ID] {
+			newID := uuid.NewString()
+			if notif.ID == "" {
+				repairs = append(repairs, "assigned ID "+newID+" to "+notif.SearchTerm)
+			} else {
+				repairs = append(repairs, "reassigned duplicate ID "+notif.ID+" to "+newID)
+			}
+			notif.ID = newID
+		}
+		seenIDs[notif.ID] = true
+
+		webhooks := make([]string, 0, len(notif.Webhooks))
+		for _, webhook := range notif.Webhooks {
+			cleaned := cleanWebhookURL(webhook)
+			switch {
+			case !isDiscordWebhookURL(cleaned):
+				repairs = append(repairs, "dropped invalid webhook from "+notif.ID)
+				continue
+			case cleaned != webhook:
+				repairs = append(repairs, "repaired webhook URL of "+notif.ID)
+			}
+			webhooks = append(webhooks, cleaned)
+		}
+		notif.Webhooks = dedupeStrings(webhooks)
+		notif.Markets = dedupeStrings(notif.Markets)
+
+		if notif.MinPrice != nil && notif.MaxPrice != nil && *notif.MinPrice > *notif.MaxPrice {
+			repairs = append(repairs, "swapped min and max price of "+notif.ID)
+			notif.MinPrice, notif.MaxPrice = notif.MaxPrice, notif.MinPrice
+		}
+
+		repaired = append(repaired, notif)
+	}
+	return repaired, repairs
+}
+
+// dedupeStrings trims values and removes empty and duplicate ones, keeping order
+func dedupeStrings(values []string) []string {
+	out := make([]string, 0, len(values))
+	seen := make(map[string]bool, len(values))
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		out = append(out, v)
+	}
+	return out
+}
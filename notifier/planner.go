@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// searchKey identifies a unique Sendico search. Notifications from different
+// users that resolve to the same key share a single request per cycle.
+type searchKey struct {
+	Term     string
+	Shop     SendicoShop
+	MinPrice int // 0 = no minimum
+	MaxPrice int // 0 = no maximum
+}
+
+func (k searchKey) options() SendicoSearchOptions {
+	opts := SendicoSearchOptions{TermJP: k.Term}
+	if k.MinPrice > 0 {
+		minPrice := k.MinPrice
+		opts.MinPrice = &minPrice
+	}
+	if k.MaxPrice > 0 {
+		maxPrice := k.MaxPrice
+		opts.MaxPrice = &maxPrice
+	}
+	return opts
+}
+
+// notificationJob is a user's notification resolved to the searches it needs
+type notificationJob struct {
+	User         User
+	Notification Notification
+	Keys         []searchKey
+	SpanContext  trace.SpanContext // notification span, linked from shared searches
+	Run          notificationRun   // filled in as the job is prepared and delivered
+}
+
+// queryPlan collects the notifications checked in a cycle, executes each
+// unique search once and fans the results back out to every notification.
+type queryPlan struct {
+	mu      sync.Mutex
+	jobs    []*notificationJob
+	keys    []searchKey
+	owners  map[searchKey]string // user a shared search is scheduled on behalf of
+	results map[searchKey][]SendicoItem
+	failed  map[searchKey]bool
+}
+
+func newQueryPlan() *queryPlan {
+	return &queryPlan{
+		owners:  make(map[searchKey]string),
+		results: make(map[searchKey][]SendicoItem),
+		failed:  make(map[searchKey]bool),
+	}
+}
+
+// add registers a job and any searches not already in the plan
+func (p *queryPlan) add(job *notificationJob) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.jobs = append(p.jobs, job)
+	for _, key := range job.Keys {
+		if _, ok := p.results[key]; ok {
+			continue
+		}
+		p.results[key] = nil
+		p.owners[key] = job.User.AuthUserID
+		p.keys = append(p.keys, key)
+	}
+}
+
+// searchCount returns the number of unique searches and the number of
+// searches that would have run without sharing
+func (p *queryPlan) searchCount() (unique, requested int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, job := range p.jobs {
+		requested += len(job.Keys)
+	}
+	return len(p.keys), requested
+}
+
+// maxInFlightSearches bounds how many of a plan's searches wait on the Sendico
+// client at once; pacing itself is left to the client's scheduler
+const maxInFlightSearches = 5
+
+// execute runs every unique search in the plan
+func (p *queryPlan) execute(ctx context.Context) {
+	p.mu.Lock()
+	owners := make(map[searchKey]string, len(p.owners))
+	for key, owner := range p.owners {
+		owners[key] = owner
+	}
+	keys := interleaveByOwner(p.keys, owners)
+
+	// Link each shared search span to the notifications that use it
+	links := make(map[searchKey][]trace.Link)
+	for _, job := range p.jobs {
+		for _, key := range job.Keys {
+			links[key] = append(links[key], trace.Link{SpanContext: job.SpanContext})
+		}
+	}
+	p.mu.Unlock()
+
+	sem := make(chan struct{}, maxInFlightSearches)
+	var wg sync.WaitGroup
+
+	for _, key := range keys {
+		wg.Add(1)
+		go func(key searchKey) {
+			defer wg.Done()
+
+			// Acquire semaphore
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			searchCtx, span := tracer.Start(ctx, "sendico.search",
+				trace.WithLinks(links[key]...),
+				trace.WithAttributes(
+					attribute.String("sendico.shop", string(key.Shop)),
+					attribute.String("sendico.term", key.Term),
+					attribute.Int("notifier.notifications", len(links[key])),
+				))
+			items, err := sendicoClient.Search(withRequestOwner(searchCtx, owners[key]), key.Shop, key.options())
+			span.SetAttributes(attribute.Int("sendico.items", len(items)))
+			endSpan(span, err)
+			if err != nil {
+				loggerFrom(ctx).Warn("search failed", "shop", key.Shop, "term", key.Term, "error", err)
+				sendicoSearches.WithLabelValues(string(key.Shop), "error").Inc()
+				p.mu.Lock()
+				p.failed[key] = true
+				p.mu.Unlock()
+			} else {
+				sendicoSearches.WithLabelValues(string(key.Shop), "success").Inc()
+				p.mu.Lock()
+				p.results[key] = items
+				p.mu.Unlock()
+			}
+		}(key)
+	}
+
+	wg.Wait()
+}
+
+// interleaveByOwner orders keys round-robin across owners so the in-flight
+// limit doesn't let one user's searches crowd out everyone else's
+func interleaveByOwner(keys []searchKey, owners map[searchKey]string) []searchKey {
+	order := make([]string, 0)
+	byOwner := make(map[string][]searchKey)
+	for _, key := range keys {
+		owner := owners[key]
+		if _, ok := byOwner[owner]; !ok {
+			order = append(order, owner)
+		}
+		byOwner[owner] = append(byOwner[owner], key)
+	}
+
+	result := make([]searchKey, 0, len(keys))
+	for len(result) < len(keys) {
+		for _, owner := range order {
+			if queue := byOwner[owner]; len(queue) > 0 {
+				result = append(result, queue[0])
+				byOwner[owner] = queue[1:]
+			}
+		}
+	}
+	return result
+}
+
+// itemsFor returns the merged, deduplicated results for a job's searches
+func (p *queryPlan) itemsFor(job *notificationJob) []SendicoItem {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	items := make([]SendicoItem, 0)
+	for _, key := range job.Keys {
+		items = append(items, p.results[key]...)
+	}
+	return dedupeItems(items)
+}
+
+// complete reports whether every one of a job's searches succeeded
+func (p *queryPlan) complete(job *notificationJob) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, key := range job.Keys {
+		if p.failed[key] {
+			return false
+		}
+	}
+	return true
+}
+
+// jobsByUser groups the plan's jobs by user, preserving order
+func (p *queryPlan) jobsByUser() [][]*notificationJob {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	index := make(map[string]int)
+	groups := make([][]*notificationJob, 0)
+	for _, job := range p.jobs {
+		i, ok := index[job.User.AuthUserID]
+		if !ok {
+			i = len(groups)
+			index[job.User.AuthUserID] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], job)
+	}
+	return groups
+}
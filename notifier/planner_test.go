@@ -0,0 +1,159 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func planJob(userID, notifID string, keys ...searchKey) *notificationJob {
+	return &notificationJob{
+		User:         User{AuthUserID: userID},
+		Notification: Notification{ID: notifID},
+		Keys:         keys,
+	}
+}
+
+func itemCodes(items []SendicoItem) []string {
+	codes := make([]string, len(items))
+	for i, item := range items {
+		codes[i] = string(item.Shop) + ":" + item.Code
+	}
+	return codes
+}
+
+func TestQueryPlanSharesSearches(t *testing.T) {
+	mercari := searchKey{Term: "ポケモン", Shop: SendicoMercari}
+	rakuma := searchKey{Term: "ポケモン", Shop: SendicoRakuma}
+	cheap := searchKey{Term: "ポケモン", Shop: SendicoMercari, MaxPrice: 5000}
+
+	tests := []struct {
+		name       string
+		jobs       []*notificationJob
+		wantUnique int
+		wantTotal  int
+	}{
+		{
+			name:       "same term and shop across users",
+			jobs:       []*notificationJob{planJob("u1", "n1", mercari), planJob("u2", "n2", mercari)},
+			wantUnique: 1,
+			wantTotal:  2,
+		},
+		{
+			name:       "different shops",
+			jobs:       []*notificationJob{planJob("u1", "n1", mercari), planJob("u2", "n2", rakuma)},
+			wantUnique: 2,
+			wantTotal:  2,
+		},
+		{
+			name:       "different price range",
+			jobs:       []*notificationJob{planJob("u1", "n1", mercari), planJob("u2", "n2", cheap)},
+			wantUnique: 2,
+			wantTotal:  2,
+		},
+		{
+			name:       "same price range",
+			jobs:       []*notificationJob{planJob("u1", "n1", cheap), planJob("u2", "n2", cheap), planJob("u2", "n3", cheap, rakuma)},
+			wantUnique: 2,
+			wantTotal:  4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := newQueryPlan()
+			for _, job := range tt.jobs {
+				plan.add(job)
+			}
+			unique, total := plan.searchCount()
+			if unique != tt.wantUnique || total != tt.wantTotal {
+				t.Errorf("searchCount() = %d, %d, want %d, %d", unique, total, tt.wantUnique, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestQueryPlanItemsForDedupesPerJob(t *testing.T) {
+	original := searchKey{Term: "pokemon", Shop: SendicoMercari}
+	translated := searchKey{Term: "ポケモン", Shop: SendicoMercari}
+	rakuma := searchKey{Term: "ポケモン", Shop: SendicoRakuma}
+
+	plan := newQueryPlan()
+	both := planJob("u1", "n1", original, translated)
+	one := planJob("u2", "n2", translated, rakuma)
+	plan.add(both)
+	plan.add(one)
+
+	// Both term variants found m1; rakuma has its own item with the same code
+	plan.results[original] = []SendicoItem{{Shop: SendicoMercari, Code: "m1"}, {Shop: SendicoMercari, Code: "m2"}}
+	plan.results[translated] = []SendicoItem{{Shop: SendicoMercari, Code: "m1"}, {Shop: SendicoMercari, Code: "m3"}}
+	plan.results[rakuma] = []SendicoItem{{Shop: SendicoRakuma, Code: "m1"}}
+
+	if got, want := itemCodes(plan.itemsFor(both)), []string{"mercari:m1", "mercari:m2", "mercari:m3"}; !slices.Equal(got, want) {
+		t.Errorf("itemsFor(u1) = %v, want %v", got, want)
+	}
+	if got, want := itemCodes(plan.itemsFor(one)), []string{"mercari:m1", "mercari:m3", "rakuma:m1"}; !slices.Equal(got, want) {
+		t.Errorf("itemsFor(u2) = %v, want %v", got, want)
+	}
+}
+
+func TestQueryPlanJobsByUser(t *testing.T) {
+	key := searchKey{Term: "pokemon", Shop: SendicoMercari}
+	plan := newQueryPlan()
+	for _, job := range []*notificationJob{
+		planJob("u1", "n1", key),
+		planJob("u2", "n2", key),
+		planJob("u1", "n3", key),
+	} {
+		plan.add(job)
+	}
+
+	var got [][]string
+	for _, group := range plan.jobsByUser() {
+		var ids []string
+		for _, job := range group {
+			ids = append(ids, job.User.AuthUserID+"/"+job.Notification.ID)
+		}
+		got = append(got, ids)
+	}
+	want := [][]string{{"u1/n1", "u1/n3"}, {"u2/n2"}}
+	if !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("jobsByUser() = %v, want %v", got, want)
+	}
+}
+
+func TestInterleaveByOwner(t *testing.T) {
+	key := func(term string) searchKey { return searchKey{Term: term, Shop: SendicoMercari} }
+	keys := []searchKey{key("a1"), key("a2"), key("a3"), key("b1"), key("c1"), key("c2")}
+	owners := map[searchKey]string{
+		key("a1"): "a", key("a2"): "a", key("a3"): "a",
+		key("b1"): "b",
+		key("c1"): "c", key("c2"): "c",
+	}
+
+	var got []string
+	for _, k := range interleaveByOwner(keys, owners) {
+		got = append(got, k.Term)
+	}
+	want := []string{"a1", "b1", "c1", "a2", "c2", "a3"}
+	if !slices.Equal(got, want) {
+		t.Errorf("interleaveByOwner() = %v, want %v", got, want)
+	}
+}
+
+func TestQueryPlanComplete(t *testing.T) {
+	mercari := searchKey{Term: "pokemon", Shop: SendicoMercari}
+	rakuma := searchKey{Term: "pokemon", Shop: SendicoRakuma}
+	plan := newQueryPlan()
+	both := planJob("u1", "n1", mercari, rakuma)
+	one := planJob("u2", "n2", mercari)
+	plan.add(both)
+	plan.add(one)
+	plan.failed[rakuma] = true
+
+	if plan.complete(both) {
+		t.Error("complete(n1) = true with a failed Rakuma search")
+	}
+	if !plan.complete(one) {
+		t.Error("complete(n2) = false, want true")
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+const (
+	newItemsRateAlpha = 0.3  // EWMA weight of the latest check
+	hotNewItemsRate   = 0.5  // Average new items per check above which we poll faster
+	coldNewItemsRate  = 0.05 // Average new items per check below which we poll slower
+	pollSpeedup       = 0.5  // Interval multiplier for hot notifications
+	pollSlowdown      = 1.5  // Interval multiplier for cold notifications
+)
+
+// pollEntry tracks when a notification is next due and how productive it has been
+type pollEntry struct {
+	Key          string
+	UserID       string
+	Notification string
+	Interval     time.Duration
+	NextRun      time.Time
+	LastRun      time.Time
+	NewItemsRate float64 // EWMA of new items per check
+	index        int     // position in the heap
+}
+
+// pollQueue is a min-heap of entries ordered by NextRun
+type pollQueue []*pollEntry
+
+func (q pollQueue) Len() int           { return len(q) }
+func (q pollQueue) Less(i, j int) bool { return q[i].NextRun.Before(q[j].NextRun) }
+func (q pollQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *pollQueue) Push(x interface{}) {
+	entry := x.(*pollEntry)
+	entry.index = len(*q)
+	*q = append(*q, entry)
+}
+
+func (q *pollQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	entry.index = -1
+	*q = old[:n-1]
+	return entry
+}
+
+// PollScheduler decides which notifications are due. Notifications start at
+// baseInterval. Ones that keep finding new items are checked more often; ones
+// that never change back off toward maxInterval. Each user's tier sets the
+// fastest interval allowed, which may be below baseInterval.
+type PollScheduler struct {
+	mu           sync.Mutex
+	entries      map[string]*pollEntry
+	queue        pollQueue
+	baseInterval time.Duration
+	maxInterval  time.Duration
+}
+
+func NewPollScheduler(baseInterval, maxInterval time.Duration) *PollScheduler {
+	return &PollScheduler{
+		entries:      make(map[string]*pollEntry),
+		baseInterval: baseInterval,
+		maxInterval:  maxInterval,
+	}
+}
+
+func pollKey(userID, notificationID string) string {
+	return userID + ":" + notificationID
+}
+
+// Sync adds entries for new notifications (due immediately) and drops entries
+// for notifications that no longer exist
+func (s *PollScheduler) Sync(users []User, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := make(map[string]bool)
+	for _, user := range users {
+		for _, notif := range user.Notifications {
+			key := pollKey(user.AuthUserID, notif.ID)
+			current[key] = true
+			if _, ok := s.entries[key]; ok {
+				continue
+			}
+			entry := &pollEntry{
+				Key:          key,
+				UserID:       user.AuthUserID,
+				Notification: notif.ID,
+				Interval:     s.clamp(s.baseInterval, user),
+				NextRun:      now,
+			}
+			s.entries[key] = entry
+			heap.Push(&s.queue, entry)
+		}
+	}
+
+	for key, entry := range s.entries {
+		if !current[key] {
+			heap.Remove(&s.queue, entry.index)
+			delete(s.entries, key)
+		}
+	}
+}
+
+// Reset forgets every notification, so the next Sync schedules them all as new
+func (s *PollScheduler) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = make(map[string]*pollEntry)
+	s.queue = nil
+}
+
+// Unchecked returns copies of users containing only their notifications this
+// instance hasn't checked yet
+func (s *PollScheduler) Unchecked(users []User) []User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unchecked := make([]User, 0)
+	for _, user := range users {
+		notifications := make([]Notification, 0)
+		for _, notif := range user.Notifications {
+			if entry, ok := s.entries[pollKey(user.AuthUserID, notif.ID)]; ok && entry.LastRun.IsZero() {
+				notifications = append(notifications, notif)
+			}
+		}
+		if len(notifications) > 0 {
+			u := user
+			u.Notifications = notifications
+			unchecked = append(unchecked, u)
+		}
+	}
+	return unchecked
+}
+
+// Due returns copies of users containing only their notifications that are due
+func (s *PollScheduler) Due(users []User, now time.Time) []User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	due := make([]User, 0)
+	for _, user := range users {
+		notifications := make([]Notification, 0)
+		for _, notif := range user.Notifications {
+			entry, ok := s.entries[pollKey(user.AuthUserID, notif.ID)]
+			if ok && !entry.NextRun.After(now) {
+				notifications = append(notifications, notif)
+			}
+		}
+		if len(notifications) > 0 {
+			u := user
+			u.Notifications = notifications
+			due = append(due, u)
+		}
+	}
+	return due
+}
+
+// Record updates a notification's history after a check and reschedules it
+func (s *PollScheduler) Record(user User, notificationID string, newItems int, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[pollKey(user.AuthUserID, notificationID)]
+	if !ok {
+		return
+	}
+
+	// The first check finds every current listing, which says nothing about
+	// how often new ones appear, so it only starts the schedule
+	if entry.LastRun.IsZero() {
+		entry.LastRun = now
+		entry.NextRun = now.Add(entry.Interval)
+		heap.Fix(&s.queue, entry.index)
+		return
+	}
+	entry.NewItemsRate = newItemsRateAlpha*float64(newItems) + (1-newItemsRateAlpha)*entry.NewItemsRate
+
+	switch {
+	case entry.NewItemsRate >= hotNewItemsRate:
+		entry.Interval = time.Duration(float64(entry.Interval) * pollSpeedup)
+	case entry.NewItemsRate < coldNewItemsRate:
+		entry.Interval = time.Duration(float64(entry.Interval) * pollSlowdown)
+	}
+
+	entry.Interval = s.clamp(entry.Interval, user)
+	entry.LastRun = now
+	entry.NextRun = now.Add(entry.Interval)
+	heap.Fix(&s.queue, entry.index)
+}
+
+// Failed reschedules a notification whose check failed at its current
+// interval. A failed check says nothing about how often new items appear, so
+// the new item rate is left alone.
+func (s *PollScheduler) Failed(user User, notificationID string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[pollKey(user.AuthUserID, notificationID)]
+	if !ok {
+		return
+	}
+	entry.Interval = s.clamp(entry.Interval, user)
+	entry.NextRun = now.Add(entry.Interval)
+	heap.Fix(&s.queue, entry.index)
+}
+
+// clamp limits interval to between the user's tier minimum and maxInterval
+func (s *PollScheduler) clamp(interval time.Duration, user User) time.Duration {
+	if minInterval := minPollIntervalFor(user); interval < minInterval {
+		interval = minInterval
+	}
+	if interval > s.maxInterval {
+		interval = s.maxInterval
+	}
+	return interval
+}
+
+// NextRun returns when the earliest notification is due (zero if none are scheduled)
+func (s *PollScheduler) NextRun() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.queue) == 0 {
+		return time.Time{}
+	}
+	return s.queue[0].NextRun
+}
+
+// Entry returns a copy of a notification's schedule
+func (s *PollScheduler) Entry(userID, notificationID string) (pollEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[pollKey(userID, notificationID)]
+	if !ok {
+		return pollEntry{}, false
+	}
+	return *entry, true
+}
+
+// minPollIntervalFor returns the fastest polling interval allowed for a user's tier
+func minPollIntervalFor(user User) time.Duration {
+	if interval := limitsFor(user).MinPollInterval; interval > 0 {
+		return interval
+	}
+	return defaultMinPollInterval
+}
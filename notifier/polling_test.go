@@ -0,0 +1,173 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func pollUser(id, tier string, notificationIDs ...string) User {
+	user := User{AuthUserID: id, Tier: tier}
+	for _, notifID := range notificationIDs {
+		user.Notifications = append(user.Notifications, Notification{ID: notifID})
+	}
+	return user
+}
+
+// recordChecks records a first check and then one check per count, a minute apart
+func recordChecks(s *PollScheduler, user User, notifID string, start time.Time, counts ...int) time.Time {
+	now := start
+	s.Record(user, notifID, 10, now)
+	for _, count := range counts {
+		now = now.Add(time.Minute)
+		s.Record(user, notifID, count, now)
+	}
+	return now
+}
+
+func TestPollSchedulerDueInNextRunOrder(t *testing.T) {
+	start := newFakeClock().Now()
+	s := NewPollScheduler(time.Minute, 15*time.Minute)
+	user := pollUser("u1", "", "a", "b", "c")
+	s.Sync([]User{user}, start)
+
+	if got := s.NextRun(); !got.Equal(start) {
+		t.Fatalf("NextRun() = %v, want new notifications due at once", got)
+	}
+
+	// Each first check schedules the next one a base interval later
+	s.Record(user, "b", 0, start)
+	s.Record(user, "a", 0, start.Add(10*time.Second))
+	s.Record(user, "c", 0, start.Add(20*time.Second))
+
+	if got, want := s.NextRun(), start.Add(time.Minute); !got.Equal(want) {
+		t.Errorf("NextRun() = %v, want %v for b", got, want)
+	}
+	due := s.Due([]User{user}, start.Add(70*time.Second))
+	if len(due) != 1 || len(due[0].Notifications) != 2 || due[0].Notifications[0].ID != "a" || due[0].Notifications[1].ID != "b" {
+		t.Errorf("Due() at +70s = %+v, want a and b", due)
+	}
+
+	// Removed notifications leave the queue
+	s.Sync([]User{pollUser("u1", "", "c")}, start)
+	if got, want := s.NextRun(), start.Add(80*time.Second); !got.Equal(want) {
+		t.Errorf("NextRun() after removing a and b = %v, want %v", got, want)
+	}
+}
+
+func TestPollSchedulerRecord(t *testing.T) {
+	start := newFakeClock().Now()
+	useTiers(t, map[string]tierLimits{"": {}, "pro": {MinPollInterval: 15 * time.Second}})
+
+	tests := []struct {
+		name         string
+		tier         string
+		counts       []int
+		wantInterval time.Duration
+	}{
+		{name: "first check only starts the schedule", counts: nil, wantInterval: time.Minute},
+		{name: "steady rate keeps the interval", counts: []int{1, 0}, wantInterval: time.Minute},
+		{name: "hot search speeds up", counts: []int{2}, wantInterval: 30 * time.Second},
+		{name: "hot search stops at the tier minimum", counts: []int{5, 5, 5, 5}, wantInterval: 30 * time.Second},
+		{name: "faster tier goes below the base interval", tier: "pro", counts: []int{5, 5, 5, 5}, wantInterval: 15 * time.Second},
+		{name: "cold search slows down", counts: []int{0}, wantInterval: 90 * time.Second},
+		{name: "cold search stops at the maximum", counts: []int{0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, wantInterval: 15 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewPollScheduler(time.Minute, 15*time.Minute)
+			user := pollUser("u1", tt.tier, "n1")
+			s.Sync([]User{user}, start)
+
+			now := recordChecks(s, user, "n1", start, tt.counts...)
+			entry, ok := s.Entry("u1", "n1")
+			if !ok {
+				t.Fatal("no entry for n1")
+			}
+			if entry.Interval != tt.wantInterval {
+				t.Errorf("Interval = %v, want %v", entry.Interval, tt.wantInterval)
+			}
+			if want := now.Add(tt.wantInterval); !entry.NextRun.Equal(want) {
+				t.Errorf("NextRun = %v, want %v", entry.NextRun, want)
+			}
+		})
+	}
+}
+
+func TestPollSchedulerNewItemsRate(t *testing.T) {
+	start := newFakeClock().Now()
+	s := NewPollScheduler(time.Minute, 15*time.Minute)
+	user := pollUser("u1", "", "n1")
+	s.Sync([]User{user}, start)
+
+	// The first check's items aren't counted
+	recordChecks(s, user, "n1", start, 1, 0)
+	entry, _ := s.Entry("u1", "n1")
+	want := 0.7 * 0.3
+	if math.Abs(entry.NewItemsRate-want) > 1e-9 {
+		t.Errorf("NewItemsRate = %v, want %v", entry.NewItemsRate, want)
+	}
+}
+
+func TestPollSchedulerFailedKeepsInterval(t *testing.T) {
+	start := newFakeClock().Now()
+	s := NewPollScheduler(time.Minute, 15*time.Minute)
+	user := pollUser("u1", "", "n1")
+	s.Sync([]User{user}, start)
+	now := recordChecks(s, user, "n1", start, 2)
+	before, _ := s.Entry("u1", "n1")
+
+	now = now.Add(time.Minute)
+	s.Failed(user, "n1", now)
+	after, _ := s.Entry("u1", "n1")
+	if after.Interval != before.Interval || after.NewItemsRate != before.NewItemsRate {
+		t.Errorf("after a failed check interval %v rate %v, want %v and %v unchanged", after.Interval, after.NewItemsRate, before.Interval, before.NewItemsRate)
+	}
+	if want := now.Add(before.Interval); !after.NextRun.Equal(want) {
+		t.Errorf("NextRun = %v, want %v", after.NextRun, want)
+	}
+	if !after.LastRun.Equal(before.LastRun) {
+		t.Errorf("LastRun = %v, want the last successful check %v", after.LastRun, before.LastRun)
+	}
+
+	// A failed first check still leaves the next successful one to start the schedule
+	s.Sync([]User{pollUser("u1", "", "n1", "n2")}, now)
+	s.Failed(user, "n2", now)
+	s.Record(user, "n2", 50, now.Add(time.Minute))
+	if entry, _ := s.Entry("u1", "n2"); entry.NewItemsRate != 0 || entry.Interval != time.Minute {
+		t.Errorf("n2 rate %v interval %v, want the first successful check ignored", entry.NewItemsRate, entry.Interval)
+	}
+}
+
+func TestPollSchedulerUncheckedAndReset(t *testing.T) {
+	start := newFakeClock().Now()
+	s := NewPollScheduler(time.Minute, 15*time.Minute)
+	users := []User{pollUser("u1", "", "a", "b"), pollUser("u2", "", "c")}
+	s.Sync(users, start)
+
+	s.Record(users[0], "a", 0, start)
+	s.Record(users[1], "c", 0, start)
+	unchecked := s.Unchecked(users)
+	if len(unchecked) != 1 || unchecked[0].AuthUserID != "u1" || len(unchecked[0].Notifications) != 1 || unchecked[0].Notifications[0].ID != "b" {
+		t.Errorf("Unchecked() = %+v, want only u1's b", unchecked)
+	}
+	// Notifications that aren't scheduled yet aren't reported
+	if got := s.Unchecked([]User{pollUser("u3", "", "d")}); len(got) != 0 {
+		t.Errorf("Unchecked() for an unsynced user = %+v, want none", got)
+	}
+
+	// After a reset every notification is new and due at once
+	s.Reset()
+	if _, ok := s.Entry("u1", "a"); ok {
+		t.Error("entry for a survived Reset()")
+	}
+	now := start.Add(10 * time.Second)
+	s.Sync(users, now)
+	if got := s.NextRun(); !got.Equal(now) {
+		t.Errorf("NextRun() after Reset() = %v, want %v", got, now)
+	}
+	if got := s.Unchecked(users); len(got) != 2 || len(got[0].Notifications) != 2 {
+		t.Errorf("Unchecked() after Reset() = %+v, want every notification", got)
+	}
+}
@@ -0,0 +1,345 @@
+// Package postgrest is a small client for the PostgREST API that Supabase
+// exposes under /rest/v1.
+//
+//	var users []User
+//	_, err := client.From("unlocked_users").
+//		Select("auth_user_id", "email").
+//		Eq("notifications_subscription_active", "true").
+//		Execute(ctx, &users)
+package postgrest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client sends requests to a PostgREST endpoint
+type Client struct {
+	BaseURL    string // e.g. https://<project>.supabase.co/rest/v1
+	APIKey     string // Sent as apikey and bearer token
+	HTTPClient *http.Client
+
+	// Failed reads and idempotent writes (network errors, 5xx, 429) are retried
+	// MaxRetries times, waiting RetryBackoff, then twice as long, and so on
+	MaxRetries   int
+	RetryBackoff time.Duration
+}
+
+// NewClient returns a client for the PostgREST API at baseURL
+func NewClient(baseURL, apiKey string) *Client {
+	return &Client{
+		BaseURL:      strings.TrimRight(baseURL, "/"),
+		APIKey:       apiKey,
+		HTTPClient:   &http.Client{Timeout: 30 * time.Second},
+		MaxRetries:   3,
+		RetryBackoff: 500 * time.Millisecond,
+	}
+}
+
+// Error is returned when PostgREST responds with a non-2xx status
+type Error struct {
+	StatusCode int
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	Details    string `json:"details"`
+	Hint       string `json:"hint"`
+	Body       string `json:"-"`
+}
+
+func (e *Error) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("postgrest: status %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("postgrest: status %d: %s", e.StatusCode, e.Body)
+}
+
+// Result describes a successful response
+type Result struct {
+	StatusCode int
+	Count      int // Total rows matching the query, or -1 if not requested
+}
+
+// Query builds a request against a single table
+type Query struct {
+	client    *Client
+	table     string
+	params    url.Values
+	rangeFrom int
+	rangeTo   int
+	hasRange  bool
+	count     bool
+}
+
+// From starts a query on table
+func (c *Client) From(table string) *Query {
+	return &Query{client: c, table: table, params: url.Values{}}
+}
+
+// Select sets the columns to return (all columns by default)
+func (q *Query) Select(columns ...string) *Query {
+	q.params.Set("select", strings.Join(columns, ","))
+	return q
+}
+
+// Filter adds a column=operator.value filter, e.g. Filter("price", "gte", "100")
+func (q *Query) Filter(column, operator, value string) *Query {
+	q.params.Add(column, operator+"."+value)
+	return q
+}
+
+// Eq filters rows where column equals value
+func (q *Query) Eq(column, value string) *Query {
+	return q.Filter(column, "eq", value)
+}
+
+// Is filters rows where column is null, true or false
+func (q *Query) Is(column, value string) *Query {
+	return q.Filter(column, "is", value)
+}
+
+// Not negates a filter, e.g. Not("discord_webhook_url", "is", "null")
+func (q *Query) Not(column, operator, value string) *Query {
+	return q.Filter(column, "not."+operator, value)
+}
+
+// In filters rows where column is one of values
+func (q *Query) In(column string, values ...string) *Query {
+	return q.Filter(column, "in", list(values))
+}
+
+// NotIn filters rows where column is none of values
+func (q *Query) NotIn(column string, values ...string) *Query {
+	return q.Not(column, "in", list(values))
+}
+
+// list formats values for the in operator, quoting each one
+func list(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = `"` + strings.ReplaceAll(v, `"`, `\"`) + `"`
+	}
+	return "(" + strings.Join(quoted, ",") + ")"
+}
+
+// Order sorts by column. Calling it again adds a secondary sort.
+func (q *Query) Order(column string, ascending bool) *Query {
+	dir := "desc"
+	if ascending {
+		dir = "asc"
+	}
+	if existing := q.params.Get("order"); existing != "" {
+		q.params.Set("order", existing+","+column+"."+dir)
+	} else {
+		q.params.Set("order", column+"."+dir)
+	}
+	return q
+}
+
+// Limit caps the number of rows returned
+func (q *Query) Limit(n int) *Query {
+	q.params.Set("limit", strconv.Itoa(n))
+	return q
+}
+
+// Range requests rows from through to (inclusive, zero-based) using the Range header
+func (q *Query) Range(from, to int) *Query {
+	q.rangeFrom, q.rangeTo, q.hasRange = from, to, true
+	return q
+}
+
+// WithCount asks PostgREST for the exact number of matching rows, returned in Result.Count
+func (q *Query) WithCount() *Query {
+	q.count = true
+	return q
+}
+
+func (q *Query) url() string {
+	u := q.client.BaseURL + "/" + q.table
+	if len(q.params) > 0 {
+		u += "?" + q.params.Encode()
+	}
+	return u
+}
+
+// Execute runs the query and decodes the rows into dest (usually a pointer to a slice)
+func (q *Query) Execute(ctx context.Context, dest interface{}) (Result, error) {
+	resp, body, err := q.client.do(ctx, http.MethodGet, q.url(), q.headers(), nil, true)
+	if err != nil {
+		return Result{}, err
+	}
+	result := Result{StatusCode: resp.StatusCode, Count: parseCount(resp.Header.Get("Content-Range"))}
+	if dest != nil {
+		if err := json.Unmarshal(body, dest); err != nil {
+			return result, fmt.Errorf("postgrest: decoding %s: %w", q.table, err)
+		}
+	}
+	return result, nil
+}
+
+// ExactCount returns the number of rows matching the query without fetching them
+func (q *Query) ExactCount(ctx context.Context) (int, error) {
+	q.count = true
+	resp, _, err := q.client.do(ctx, http.MethodHead, q.url(), q.headers(), nil, true)
+	if err != nil {
+		return 0, err
+	}
+	count := parseCount(resp.Header.Get("Content-Range"))
+	if count < 0 {
+		return 0, fmt.Errorf("postgrest: no count in Content-Range %q", resp.Header.Get("Content-Range"))
+	}
+	return count, nil
+}
+
+// Insert adds rows (a struct, map or slice of them) to the table
+func (q *Query) Insert(ctx context.Context, rows interface{}) error {
+	return q.write(ctx, http.MethodPost, rows, "return=minimal", false)
+}
+
+// Upsert inserts rows, updating existing rows that conflict on onConflict
+// (a comma-separated list of columns with a unique constraint)
+func (q *Query) Upsert(ctx context.Context, rows interface{}, onConflict string) error {
+	q.params.Set("on_conflict", onConflict)
+	return q.write(ctx, http.MethodPost, rows, "resolution=merge-duplicates,return=minimal", true)
+}
+
+// Update sets the given columns on every row matching the query's filters
+func (q *Query) Update(ctx context.Context, values interface{}) error {
+	return q.write(ctx, http.MethodPatch, values, "return=minimal", true)
+}
+
+// Delete removes every row matching the query's filters
+func (q *Query) Delete(ctx context.Context) error {
+	header := http.Header{}
+	header.Set("Prefer", "return=minimal")
+	_, _, err := q.client.do(ctx, http.MethodDelete, q.url(), header, nil, true)
+	return err
+}
+
+// write sends a body to the table. Only idempotent writes are retried, since a
+// failed insert may have been applied before the connection dropped.
+func (q *Query) write(ctx context.Context, method string, values interface{}, prefer string, idempotent bool) error {
+	body, err := json.Marshal(values)
+	if err != nil {
+		return fmt.Errorf("postgrest: encoding %s: %w", q.table, err)
+	}
+	header := http.Header{}
+	header.Set("Prefer", prefer)
+	_, _, err = q.client.do(ctx, method, q.url(), header, body, idempotent)
+	return err
+}
+
+func (q *Query) headers() http.Header {
+	h := http.Header{}
+	if q.hasRange {
+		h.Set("Range-Unit", "items")
+		h.Set("Range", fmt.Sprintf("%d-%d", q.rangeFrom, q.rangeTo))
+	}
+	if q.count {
+		h.Set("Prefer", "count=exact")
+	}
+	return h
+}
+
+// All fetches every row matching q, pageSize rows at a time. The query
+// should be ordered by a unique column so pages don't shift between requests.
+func All[T any](ctx context.Context, q *Query, pageSize int) ([]T, error) {
+	var rows []T
+	for from := 0; ; from += pageSize {
+		var page []T
+		if _, err := q.Range(from, from+pageSize-1).Execute(ctx, &page); err != nil {
+			return nil, err
+		}
+		rows = append(rows, page...)
+		if len(page) < pageSize {
+			return rows, nil
+		}
+	}
+}
+
+// parseCount extracts the total from a Content-Range header such as
+// "0-24/3573" or "*/3573", returning -1 if it is unknown
+func parseCount(contentRange string) int {
+	_, total, ok := strings.Cut(contentRange, "/")
+	if !ok || total == "*" {
+		return -1
+	}
+	n, err := strconv.Atoi(total)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// do sends a request, retrying transient failures if it is idempotent, and
+// returns the response with its body already read
+func (c *Client) do(ctx context.Context, method, url string, header http.Header, body []byte, idempotent bool) (*http.Response, []byte, error) {
+	backoff := c.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		resp, respBody, err := c.doOnce(ctx, method, url, header, body)
+		if err == nil || !idempotent || attempt >= c.MaxRetries || ctx.Err() != nil || !retryable(err) {
+			return resp, respBody, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) doOnce(ctx context.Context, method, url string, header http.Header, body []byte) (*http.Response, []byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("postgrest: creating request: %w", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("apikey", c.APIKey)
+	req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &Error{StatusCode: resp.StatusCode, Body: string(respBody)}
+		_ = json.Unmarshal(respBody, apiErr)
+		return resp, respBody, apiErr
+	}
+	return resp, respBody, nil
+}
+
+// retryable reports whether a failed request may succeed if sent again
+func retryable(err error) bool {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+	}
+	return true // Network error
+}
@@ -0,0 +1,270 @@
+package postgrest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"discord-notifier/postgrest/postgresttest"
+)
+
+type user struct {
+	ID     string `json:"auth_user_id"`
+	Email  string `json:"email"`
+	Active bool   `json:"active"`
+}
+
+func newTestClient(t *testing.T) (*Client, *postgresttest.Server) {
+	t.Helper()
+	srv := postgresttest.NewServer()
+	t.Cleanup(srv.Close)
+	client := NewClient(srv.URL, "key")
+	client.RetryBackoff = time.Millisecond
+	return client, srv
+}
+
+func seedUsers(srv *postgresttest.Server, n int) {
+	rows := make([]postgresttest.Row, n)
+	for i := range rows {
+		rows[i] = postgresttest.Row{
+			"auth_user_id": string(rune('a' + i)),
+			"email":        string(rune('a'+i)) + "@example.com",
+			"active":       i%2 == 0,
+		}
+	}
+	srv.SetRows("users", rows)
+}
+
+func TestQueryBuildsFiltersAndOrder(t *testing.T) {
+	client, srv := newTestClient(t)
+	seedUsers(srv, 5)
+
+	var users []user
+	_, err := client.From("users").
+		Select("auth_user_id", "email").
+		Eq("active", "true").
+		NotIn("auth_user_id", "a", `we"ird`).
+		Order("email", false).
+		Order("auth_user_id", true).
+		Limit(10).
+		Execute(context.Background(), &users)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := srv.Requests()[0].URL.Query()
+	want := url.Values{
+		"select":       {"auth_user_id,email"},
+		"active":       {"eq.true"},
+		"auth_user_id": {`not.in.("a","we\"ird")`},
+		"order":        {"email.desc,auth_user_id.asc"},
+		"limit":        {"10"},
+	}
+	for key, values := range want {
+		if got := query[key]; len(got) != 1 || got[0] != values[0] {
+			t.Errorf("query %s = %q, want %q", key, got, values[0])
+		}
+	}
+
+	if len(users) != 2 || users[0].ID != "e" || users[1].ID != "c" {
+		t.Fatalf("users = %+v, want e then c", users)
+	}
+	if users[0].Email != "e@example.com" || users[0].Active {
+		t.Errorf("users[0] = %+v, want only the selected columns", users[0])
+	}
+}
+
+func TestAllFetchesEveryPage(t *testing.T) {
+	client, srv := newTestClient(t)
+	seedUsers(srv, 5)
+
+	users, err := All[user](context.Background(), client.From("users").Order("auth_user_id", true), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 5 {
+		t.Fatalf("got %d users, want 5", len(users))
+	}
+	for i, u := range users {
+		if want := string(rune('a' + i)); u.ID != want {
+			t.Errorf("users[%d] = %q, want %q", i, u.ID, want)
+		}
+	}
+
+	var ranges []string
+	for _, r := range srv.Requests() {
+		if r.Header.Get("Range-Unit") != "items" {
+			t.Errorf("Range-Unit = %q, want items", r.Header.Get("Range-Unit"))
+		}
+		ranges = append(ranges, r.Header.Get("Range"))
+	}
+	if want := []string{"0-1", "2-3", "4-5"}; !slices.Equal(ranges, want) {
+		t.Errorf("ranges = %q, want %q", ranges, want)
+	}
+}
+
+func TestAllStopsOnAnEmptyLastPage(t *testing.T) {
+	client, srv := newTestClient(t)
+	seedUsers(srv, 4)
+
+	users, err := All[user](context.Background(), client.From("users").Order("auth_user_id", true), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 4 || len(srv.Requests()) != 3 {
+		t.Errorf("got %d users in %d requests, want 4 in 3", len(users), len(srv.Requests()))
+	}
+}
+
+func TestCounts(t *testing.T) {
+	client, srv := newTestClient(t)
+	seedUsers(srv, 5)
+
+	count, err := client.From("users").Eq("active", "true").ExactCount(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("ExactCount = %d, want 3", count)
+	}
+	r := srv.Requests()[0]
+	if r.Method != http.MethodHead || r.Header.Get("Prefer") != "count=exact" {
+		t.Errorf("sent %s with Prefer %q, want HEAD with count=exact", r.Method, r.Header.Get("Prefer"))
+	}
+
+	var users []user
+	result, err := client.From("users").Range(0, 1).WithCount().Execute(context.Background(), &users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Count != 5 || len(users) != 2 {
+		t.Errorf("got count %d with %d rows, want 5 with 2", result.Count, len(users))
+	}
+
+	result, err = client.From("users").Execute(context.Background(), &users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Count != -1 {
+		t.Errorf("count without WithCount = %d, want -1", result.Count)
+	}
+}
+
+func TestParseCount(t *testing.T) {
+	tests := map[string]int{
+		"0-24/3573": 3573,
+		"*/0":       0,
+		"*/12":      12,
+		"0-24/*":    -1,
+		"":          -1,
+		"0-24/abc":  -1,
+	}
+	for header, want := range tests {
+		if got := parseCount(header); got != want {
+			t.Errorf("parseCount(%q) = %d, want %d", header, got, want)
+		}
+	}
+}
+
+func TestRetriesTransientFailures(t *testing.T) {
+	client, srv := newTestClient(t)
+	seedUsers(srv, 1)
+	srv.FailNext(http.StatusServiceUnavailable, http.StatusTooManyRequests)
+
+	var users []user
+	if _, err := client.From("users").Execute(context.Background(), &users); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || len(srv.Requests()) != 3 {
+		t.Errorf("got %d users in %d requests, want 1 in 3", len(users), len(srv.Requests()))
+	}
+}
+
+func TestGivesUpAfterMaxRetries(t *testing.T) {
+	client, srv := newTestClient(t)
+	seedUsers(srv, 1)
+	srv.FailNext(500, 500, 500, 500, 500)
+
+	_, err := client.From("users").Execute(context.Background(), nil)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 500 {
+		t.Fatalf("err = %v, want a 500 *Error", err)
+	}
+	if got := len(srv.Requests()); got != client.MaxRetries+1 {
+		t.Errorf("sent %d requests, want %d", got, client.MaxRetries+1)
+	}
+}
+
+func TestDoesNotRetryClientErrorsOrInserts(t *testing.T) {
+	client, srv := newTestClient(t)
+	seedUsers(srv, 1)
+
+	srv.FailNext(http.StatusBadRequest)
+	if _, err := client.From("users").Execute(context.Background(), nil); err == nil {
+		t.Fatal("expected the 400 to be returned")
+	}
+	if got := len(srv.Requests()); got != 1 {
+		t.Errorf("sent %d requests for a 400, want 1", got)
+	}
+
+	srv.FailNext(http.StatusServiceUnavailable)
+	if err := client.From("users").Insert(context.Background(), user{ID: "z"}); err == nil {
+		t.Fatal("expected the 503 to be returned")
+	}
+	if got := len(srv.Requests()); got != 2 {
+		t.Errorf("sent %d requests in total, want an insert to be tried once", got)
+	}
+
+	srv.FailNext(http.StatusServiceUnavailable)
+	if err := client.From("users").Eq("auth_user_id", "a").Update(context.Background(), map[string]bool{"active": false}); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(srv.Requests()); got != 4 {
+		t.Errorf("sent %d requests in total, want an update to be retried", got)
+	}
+}
+
+func TestDecodesErrors(t *testing.T) {
+	client, srv := newTestClient(t)
+	seedUsers(srv, 1)
+	srv.SetPrimaryKey("users", "auth_user_id")
+
+	err := client.From("users").Insert(context.Background(), user{ID: "a"})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("err = %v, want *Error", err)
+	}
+	if apiErr.StatusCode != http.StatusConflict || apiErr.Code != "23505" || apiErr.Message == "" {
+		t.Errorf("err = %+v, want a 409 with code 23505 and a message", apiErr)
+	}
+	if apiErr.Error() != "postgrest: status 409: "+apiErr.Message {
+		t.Errorf("Error() = %q", apiErr.Error())
+	}
+}
+
+func TestKeepsNonJSONErrorBodies(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		_, _ = w.Write([]byte("upstream unavailable"))
+	}))
+	defer srv.Close()
+	client := NewClient(srv.URL, "key")
+	client.MaxRetries = 0
+
+	_, err := client.From("users").Execute(context.Background(), nil)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("err = %v, want *Error", err)
+	}
+	if apiErr.Body != "upstream unavailable" || apiErr.Message != "" {
+		t.Errorf("err = %+v, want the raw body and no message", apiErr)
+	}
+	if got, want := apiErr.Error(), "postgrest: status 502: upstream unavailable"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
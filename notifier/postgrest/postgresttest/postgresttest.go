@@ -0,0 +1,417 @@
+// Package postgresttest provides an in-memory PostgREST server for tests.
+//
+//	srv := postgresttest.NewServer()
+//	defer srv.Close()
+//	srv.SetRows("unlocked_users", []map[string]interface{}{{"auth_user_id": "u1"}})
+//	client := postgrest.NewClient(srv.URL, "key")
+//
+// It understands the subset of PostgREST the notifier uses: select, eq, neq,
+// lt, lte, gt, gte, is and in filters (optionally negated with not.), order,
+// limit, the Range header, Prefer: count=exact, and inserts, upserts
+// (on_conflict with Prefer: resolution=merge-duplicates), updates and
+// deletes. Tables must be created with SetRows before use. Inserts conflicting
+// with a key set by SetPrimaryKey fail with 409 Conflict.
+package postgresttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Row is a table row keyed by column name
+type Row = map[string]interface{}
+
+// Server is a fake PostgREST endpoint backed by in-memory tables
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	tables   map[string][]Row
+	keys     map[string][]string // primary key columns by table
+	failures []int               // status codes to return for the next requests
+	requests []*http.Request
+}
+
+// NewServer starts a fake PostgREST server. Close it when done.
+func NewServer() *Server {
+	s := &Server{tables: make(map[string][]Row), keys: make(map[string][]string)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// SetRows replaces the contents of table
+func (s *Server) SetRows(table string, rows []Row) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tables[table] = append([]Row(nil), rows...)
+}
+
+// SetPrimaryKey makes plain inserts into table fail with 409 Conflict when a
+// row with the same values in columns exists
+func (s *Server) SetPrimaryKey(table string, columns ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[table] = columns
+}
+
+// Rows returns a copy of the contents of table
+func (s *Server) Rows(table string) []Row {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Row(nil), s.tables[table]...)
+}
+
+// FailNext makes the next len(statuses) requests fail with the given status codes
+func (s *Server) FailNext(statuses ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, statuses...)
+}
+
+// Requests returns the requests received so far
+func (s *Server) Requests() []*http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*http.Request(nil), s.requests...)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r)
+
+	if len(s.failures) > 0 {
+		status := s.failures[0]
+		s.failures = s.failures[1:]
+		writeError(w, status, "injected failure")
+		return
+	}
+	if r.Header.Get("apikey") == "" {
+		writeError(w, http.StatusUnauthorized, "No API key found in request")
+		return
+	}
+
+	table := strings.Trim(r.URL.Path, "/")
+	rows, ok := s.tables[table]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("relation \"public.%s\" does not exist", table))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		s.handleSelect(w, r, rows)
+	case http.MethodPost:
+		s.handleInsert(w, r, table, rows)
+	case http.MethodPatch:
+		s.handleUpdate(w, r, table, rows)
+	case http.MethodDelete:
+		kept := make([]Row, 0, len(rows))
+		for _, row := range rows {
+			if !matches(row, r.URL.Query()) {
+				kept = append(kept, row)
+			}
+		}
+		s.tables[table] = kept
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not supported by postgresttest")
+	}
+}
+
+func (s *Server) handleSelect(w http.ResponseWriter, r *http.Request, rows []Row) {
+	query := r.URL.Query()
+
+	matched := make([]Row, 0, len(rows))
+	for _, row := range rows {
+		if matches(row, query) {
+			matched = append(matched, row)
+		}
+	}
+
+	if order := query.Get("order"); order != "" {
+		sortRows(matched, order)
+	}
+
+	from, to := 0, len(matched)-1
+	if limit := query.Get("limit"); limit != "" {
+		if n, err := strconv.Atoi(limit); err == nil && n-1 < to {
+			to = n - 1
+		}
+	}
+	if rng := r.Header.Get("Range"); rng != "" {
+		a, b, _ := strings.Cut(rng, "-")
+		if n, err := strconv.Atoi(a); err == nil {
+			from = n
+		}
+		if n, err := strconv.Atoi(b); err == nil && n < to {
+			to = n
+		}
+	}
+
+	page := []Row{}
+	if from <= to && from < len(matched) {
+		page = matched[from : to+1]
+	}
+
+	var columns []string
+	if sel := query.Get("select"); sel != "" && sel != "*" {
+		columns = strings.Split(sel, ",")
+	}
+	out := make([]Row, len(page))
+	for i, row := range page {
+		out[i] = project(row, columns)
+	}
+
+	total := "*"
+	if strings.Contains(r.Header.Get("Prefer"), "count=exact") {
+		total = strconv.Itoa(len(matched))
+	}
+	if len(page) == 0 {
+		w.Header().Set("Content-Range", "*/"+total)
+	} else {
+		w.Header().Set("Content-Range", fmt.Sprintf("%d-%d/%s", from, from+len(page)-1, total))
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	status := http.StatusOK
+	if r.Header.Get("Range") != "" && len(page) < len(matched) {
+		status = http.StatusPartialContent
+	}
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+func (s *Server) handleInsert(w http.ResponseWriter, r *http.Request, table string, rows []Row) {
+	inserted, err := decodeRows(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var conflictColumns []string
+	if onConflict := r.URL.Query().Get("on_conflict"); onConflict != "" && strings.Contains(r.Header.Get("Prefer"), "resolution=merge-duplicates") {
+		conflictColumns = strings.Split(onConflict, ",")
+	}
+
+	if conflictColumns == nil && s.keys[table] != nil {
+		for _, row := range inserted {
+			for _, existing := range rows {
+				if sameKey(existing, row, s.keys[table]) {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusConflict)
+					_ = json.NewEncoder(w).Encode(map[string]string{
+						"code":    "23505",
+						"message": fmt.Sprintf("duplicate key value violates unique constraint \"%s_pkey\"", table),
+					})
+					return
+				}
+			}
+		}
+	}
+
+	for _, row := range inserted {
+		merged := false
+		if conflictColumns != nil {
+			for _, existing := range rows {
+				if sameKey(existing, row, conflictColumns) {
+					for k, v := range row {
+						existing[k] = v
+					}
+					merged = true
+					break
+				}
+			}
+		}
+		if !merged {
+			rows = append(rows, row)
+		}
+	}
+	s.tables[table] = rows
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) handleUpdate(w http.ResponseWriter, r *http.Request, table string, rows []Row) {
+	var values Row
+	if err := json.NewDecoder(r.Body).Decode(&values); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	for _, row := range rows {
+		if matches(row, r.URL.Query()) {
+			for k, v := range values {
+				row[k] = v
+			}
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeRows reads a JSON object or array of objects from the request body
+func decodeRows(r *http.Request) ([]Row, error) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		return nil, err
+	}
+	var rows []Row
+	if err := json.Unmarshal(raw, &rows); err == nil {
+		return rows, nil
+	}
+	var row Row
+	if err := json.Unmarshal(raw, &row); err != nil {
+		return nil, err
+	}
+	return []Row{row}, nil
+}
+
+func sameKey(a, b Row, columns []string) bool {
+	for _, column := range columns {
+		if format(a[column]) != format(b[column]) {
+			return false
+		}
+	}
+	return true
+}
+
+// reservedParams are query parameters that aren't column filters
+var reservedParams = map[string]bool{"select": true, "order": true, "limit": true, "offset": true, "on_conflict": true, "columns": true}
+
+func matches(row Row, query map[string][]string) bool {
+	for column, filters := range query {
+		if reservedParams[column] {
+			continue
+		}
+		for _, filter := range filters {
+			if !matchFilter(row[column], filter) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func matchFilter(value interface{}, filter string) bool {
+	negate := false
+	if rest, ok := strings.CutPrefix(filter, "not."); ok {
+		negate = true
+		filter = rest
+	}
+	op, operand, _ := strings.Cut(filter, ".")
+
+	var result bool
+	switch op {
+	case "eq":
+		result = value != nil && format(value) == operand
+	case "neq":
+		result = value != nil && format(value) != operand
+	case "lt", "lte", "gt", "gte":
+		if value == nil {
+			break
+		}
+		cmp := compare(format(value), operand)
+		switch op {
+		case "lt":
+			result = cmp < 0
+		case "lte":
+			result = cmp <= 0
+		case "gt":
+			result = cmp > 0
+		case "gte":
+			result = cmp >= 0
+		}
+	case "is":
+		switch operand {
+		case "null":
+			result = value == nil
+		case "true":
+			result = value == true
+		case "false":
+			result = value == false
+		}
+	case "in":
+		for _, candidate := range strings.Split(strings.Trim(operand, "()"), ",") {
+			if value != nil && format(value) == strings.Trim(candidate, `"`) {
+				result = true
+			}
+		}
+	default:
+		panic("postgresttest: unsupported operator " + op)
+	}
+	return result != negate
+}
+
+// compare orders two values numerically if both are numbers, otherwise as
+// strings (which suits timestamps in the same format)
+func compare(a, b string) int {
+	x, errA := strconv.ParseFloat(a, 64)
+	y, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(a, b)
+}
+
+func sortRows(rows []Row, order string) {
+	keys := strings.Split(order, ",")
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, key := range keys {
+			column, dir, _ := strings.Cut(key, ".")
+			a, b := format(rows[i][column]), format(rows[j][column])
+			if a == b {
+				continue
+			}
+			if dir == "desc" {
+				return a > b
+			}
+			return a < b
+		}
+		return false
+	})
+}
+
+func project(row Row, columns []string) Row {
+	if columns == nil {
+		out := make(Row, len(row))
+		for k, v := range row {
+			out[k] = v
+		}
+		return out
+	}
+	out := make(Row, len(columns))
+	for _, column := range columns {
+		out[column] = row[column]
+	}
+	return out
+}
+
+// format renders a value the way it appears in a PostgREST filter
+func format(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
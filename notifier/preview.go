@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// runPreview implements the "preview" subcommand: it runs a saved search once
+// (translation, the planned Sendico searches and filters) and prints the
+// listings and the Discord payloads that would be sent. Seen items and
+// webhooks are left untouched.
+//
+//	notifier preview --term "..." --markets mercari-jp,rakuma --min 5000 [--template format.json]
+func runPreview(args []string) {
+	fs := flag.NewFlagSet("preview", flag.ExitOnError)
+	term := fs.String("term", "", "search term (required)")
+	markets := fs.String("markets", "", "comma-separated markets (default: all supported)")
+	aliases := fs.String("aliases", "", "comma-separated alternative search terms")
+	minPrice := fs.Int("min", 0, "minimum price in yen (0 for none)")
+	maxPrice := fs.Int("max", 0, "maximum price in yen (0 for none)")
+	currency := fs.String("currency", defaultCurrency, "currency prices are shown in next to yen")
+	templateFile := fs.String("template", "", "JSON file with a message template")
+	fs.Parse(args)
+
+	if strings.TrimSpace(*term) == "" {
+		fmt.Fprintln(os.Stderr, "preview: --term is required")
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	notif := Notification{
+		ID:         "preview",
+		SearchTerm: *term,
+		Markets:    splitList(*markets),
+		Aliases:    splitList(*aliases),
+	}
+	if *minPrice > 0 {
+		notif.MinPrice = minPrice
+	}
+	if *maxPrice > 0 {
+		notif.MaxPrice = maxPrice
+	}
+	if *templateFile != "" {
+		data, err := os.ReadFile(*templateFile)
+		if err != nil {
+			fatal("failed to read template", "error", err)
+		}
+		notif.Template = &MessageTemplate{}
+		if err := json.Unmarshal(data, notif.Template); err != nil {
+			fatal("failed to parse template", "file", *templateFile, "error", err)
+		}
+	}
+	user := User{AuthUserID: "preview"}
+
+	initSendico()
+	ctx := context.Background()
+
+	job := prepareNotificationJob(ctx, user, notif)
+	if job == nil {
+		run, _ := notificationRuns.Get(user.AuthUserID, notif.ID)
+		fatal("nothing to search", "reason", run.ErrorCategory)
+	}
+	if job.Run.Error != "" {
+		fmt.Fprintf(os.Stderr, "warning: %s: %s\n", job.Run.ErrorCategory, job.Run.Error)
+	}
+	fmt.Printf("Search terms: %s\n", strings.Join(job.Run.Terms, ", "))
+
+	plan := newQueryPlan()
+	plan.add(job)
+	plan.execute(ctx)
+	items := plan.itemsFor(job)
+
+	fmt.Printf("Found %d listings:\n", len(items))
+	for _, item := range items {
+		fmt.Printf("  [%s] ¥%d  %s\n      %s\n", item.Shop, item.PriceYen, item.Name, item.URL)
+	}
+	if len(items) == 0 {
+		return
+	}
+
+	fmt.Println("\nDiscord payloads:")
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	alerts := newAlertItems(items)
+	translateTitles(ctx, alerts)
+	for _, payload := range buildDiscordPayloads(notif, alerts, *currency) {
+		enc.Encode(payload)
+	}
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Subscriber sync modes. In poll mode the full subscriber list is re-read
+// every pollInterval. In realtime mode changes to unlocked_users are streamed
+// over Supabase Realtime and applied to the in-memory index as they happen;
+// the full list is only re-read every realtimeResyncInterval, or every
+// pollInterval while the subscription is down.
+const (
+	syncModePoll     = "poll"
+	syncModeRealtime = "realtime"
+)
+
+var (
+	subscriberSyncMode     = syncModePoll
+	realtimeResyncInterval = 15 * time.Minute
+	realtimeHeartbeat      = 25 * time.Second // Phoenix closes sockets silent for 60s
+	realtimeMaxBackoff     = 1 * time.Minute
+
+	// subscribersChanged wakes the main loop after a realtime update so new
+	// notifications are checked right away
+	subscribersChanged = make(chan struct{}, 1)
+)
+
+// realtimeState tracks the Realtime subscription for the sync loop
+type realtimeState struct {
+	mu        sync.Mutex
+	connected bool
+	resync    bool   // a full resync is needed (e.g. changes may have been missed)
+	changes   uint64 // incremental updates applied so far
+}
+
+var realtime = &realtimeState{}
+
+func (r *realtimeState) setConnected(connected bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.connected = connected
+	if connected {
+		// Changes made while we were disconnected were never delivered
+		r.resync = true
+		realtimeConnected.Set(1)
+	} else {
+		realtimeConnected.Set(0)
+	}
+}
+
+func (r *realtimeState) Connected() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.connected
+}
+
+// RequestResync asks the next cycle to re-read all subscribers
+func (r *realtimeState) RequestResync() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.resync = true
+}
+
+// TakeResync reports whether a resync was requested and clears the request
+func (r *realtimeState) TakeResync() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	resync := r.resync
+	r.resync = false
+	return resync
+}
+
+// Changes returns the number of incremental updates applied so far
+func (r *realtimeState) Changes() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.changes
+}
+
+func (r *realtimeState) changed() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.changes++
+}
+
+// subscriberResyncInterval returns how often the full subscriber list is re-read
+func subscriberResyncInterval() time.Duration {
+	if subscriberSyncMode == syncModeRealtime && realtime.Connected() {
+		return realtimeResyncInterval
+	}
+	return pollInterval
+}
+
+// phoenixMessage is a Phoenix channel message as used by Supabase Realtime
+type phoenixMessage struct {
+	Topic   string          `json:"topic"`
+	Event   string          `json:"event"`
+	Payload json.RawMessage `json:"payload"`
+	Ref     string          `json:"ref,omitempty"`
+	JoinRef string          `json:"join_ref,omitempty"`
+}
+
+type phoenixReply struct {
+	Status   string          `json:"status"`
+	Response json.RawMessage `json:"response"`
+}
+
+// realtimeChange is the payload of a postgres_changes event
+type realtimeChange struct {
+	Data struct {
+		Type      string          `json:"type"` // INSERT, UPDATE or DELETE
+		Table     string          `json:"table"`
+		Record    json.RawMessage `json:"record"`
+		OldRecord json.RawMessage `json:"old_record"`
+	} `json:"data"`
+}
+
+const realtimeTopic = "realtime:notifier-unlocked-users"
+
+// realtimeTables returns the postgres_changes subscriptions for the channel
+func realtimeTables() []map[string]string {
+	tables := []map[string]string{
+		{"event": "*", "schema": "public", "table": "unlocked_users"},
+	}
+	if notificationsSource == notificationsSourceTable {
+		tables = append(tables, map[string]string{"event": "*", "schema": "public", "table": "user_notifications"})
+	}
+	return tables
+}
+
+// runRealtime keeps a Realtime subscription to unlocked_users open,
+// reconnecting with backoff until ctx is cancelled
+func runRealtime(ctx context.Context) {
+	backoff := time.Second
+	for {
+		start := time.Now()
+		err := realtimeSession(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > realtimeMaxBackoff {
+			backoff = time.Second // The session was healthy for a while
+		}
+		slog.Warn("realtime subscription lost, reconnecting", "error", err, "retry_in", backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, realtimeMaxBackoff)
+	}
+}
+
+// realtimeURL returns the Realtime websocket URL for the Supabase project
+func realtimeURL() (string, error) {
+	u, err := url.Parse(supabaseURL)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	}
+	u.Path = "/realtime/v1/websocket"
+	u.RawQuery = url.Values{"apikey": {supabaseKey}, "vsn": {"1.0.0"}}.Encode()
+	return u.String(), nil
+}
+
+// realtimeSession runs one websocket connection until it fails
+func realtimeSession(ctx context.Context) error {
+	wsURL, err := realtimeURL()
+	if err != nil {
+		return err
+	}
+	config, err := websocket.NewConfig(wsURL, supabaseURL)
+	if err != nil {
+		return err
+	}
+	config.Dialer = &net.Dialer{Timeout: 10 * time.Second}
+
+	conn, err := websocket.DialConfig(config)
+	if err != nil {
+		// DialError includes the URL, which carries the API key
+		var dialErr *websocket.DialError
+		if errors.As(err, &dialErr) {
+			err = dialErr.Err
+		}
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer conn.Close()
+
+	// Unblock reads when we're asked to stop
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	var writeMu sync.Mutex
+	var ref int
+	send := func(topic, event string, payload interface{}) (string, error) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return "", err
+		}
+		ref++
+		msg := phoenixMessage{Topic: topic, Event: event, Payload: data, Ref: strconv.Itoa(ref)}
+		if topic == realtimeTopic {
+			msg.JoinRef = "1"
+		}
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return msg.Ref, websocket.JSON.Send(conn, msg)
+	}
+
+	joinRef, err := send(realtimeTopic, "phx_join", map[string]interface{}{
+		"config": map[string]interface{}{
+			"broadcast":        map[string]bool{"self": false},
+			"presence":         map[string]string{"key": ""},
+			"postgres_changes": realtimeTables(),
+		},
+		"access_token": supabaseKey,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to join channel: %w", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(realtimeHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if _, err := send("phoenix", "heartbeat", struct{}{}); err != nil {
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	joined := false
+	defer func() {
+		if joined {
+			realtime.setConnected(false)
+		}
+	}()
+
+	for {
+		// Heartbeat replies keep the read deadline moving on an idle socket
+		conn.SetReadDeadline(time.Now().Add(2 * realtimeHeartbeat))
+
+		var msg phoenixMessage
+		if err := websocket.JSON.Receive(conn, &msg); err != nil {
+			return fmt.Errorf("failed to read: %w", err)
+		}
+
+		switch msg.Event {
+		case "phx_reply":
+			var reply phoenixReply
+			if err := json.Unmarshal(msg.Payload, &reply); err != nil {
+				return fmt.Errorf("invalid reply: %w", err)
+			}
+			if reply.Status != "ok" {
+				return fmt.Errorf("%s %s failed: %s", msg.Topic, msg.Event, reply.Response)
+			}
+			if msg.Topic == "phoenix" {
+				health.SupabaseResult(time.Now(), nil)
+			}
+			if msg.Topic == realtimeTopic && msg.Ref == joinRef && !joined {
+				joined = true
+				realtime.setConnected(true)
+				slog.Info("subscribed to subscriber changes over Supabase Realtime")
+			}
+		case "system":
+			var status struct {
+				Status  string `json:"status"`
+				Message string `json:"message"`
+			}
+			json.Unmarshal(msg.Payload, &status)
+			if status.Status == "error" {
+				return errors.New("realtime error: " + status.Message)
+			}
+			slog.Debug("realtime system message", "message", status.Message)
+		case "phx_error", "phx_close":
+			return fmt.Errorf("channel %s: %s", msg.Event, msg.Payload)
+		case "postgres_changes":
+			var change realtimeChange
+			if err := json.Unmarshal(msg.Payload, &change); err != nil {
+				slog.Warn("invalid realtime change, scheduling resync", "error", err)
+				realtime.RequestResync()
+				continue
+			}
+			applyRealtimeChange(change)
+		}
+	}
+}
+
+// applyRealtimeChange updates the subscriber index from a change to
+// unlocked_users or user_notifications
+func applyRealtimeChange(change realtimeChange) {
+	changeType := strings.ToUpper(change.Data.Type)
+	realtimeChanges.WithLabelValues(changeType).Inc()
+
+	record := change.Data.Record
+	if changeType == "DELETE" {
+		record = change.Data.OldRecord
+	}
+
+	var user User
+	if err := json.Unmarshal(record, &user); err != nil || user.AuthUserID == "" {
+		// Without REPLICA IDENTITY FULL, deletes only carry the primary key
+		slog.Debug("realtime change without auth_user_id, scheduling resync", "type", changeType, "table", change.Data.Table)
+		realtime.RequestResync()
+		return
+	}
+	if !ownsUser(user.AuthUserID) {
+		return // Another instance's shard
+	}
+	logger := slog.With("user_id", user.AuthUserID, "type", changeType, "table", change.Data.Table)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if change.Data.Table == "user_notifications" {
+		// Re-read the user's saved searches; non-subscribers are ignored
+		existing, ok := findSubscriber(user.AuthUserID)
+		if !ok {
+			return
+		}
+		notifications, err := fetchUserNotifications(ctx, user.AuthUserID)
+		if err != nil {
+			logger.Warn("failed to fetch notifications, scheduling resync", "error", err)
+			realtime.RequestResync()
+			return
+		}
+		existing.Notifications = notifications
+		existing.LimitNotices = nil
+		applyTierLimits(&existing)
+		logger.Info("subscriber notifications updated", "notifications", len(notifications))
+		updateSubscriber(existing, true)
+		return
+	}
+
+	if notificationsSource == notificationsSourceTable && changeType != "DELETE" {
+		// The row's blob is legacy; keep the notifications from user_notifications,
+		// unless a tier change means a different set of them is allowed
+		if existing, ok := findSubscriber(user.AuthUserID); ok && existing.Tier == user.Tier {
+			user.Notifications = existing.Notifications
+			user.LimitNotices = existing.LimitNotices
+		} else if notifications, err := fetchUserNotifications(ctx, user.AuthUserID); err == nil {
+			user.Notifications = notifications
+		} else {
+			logger.Warn("failed to fetch notifications, scheduling resync", "error", err)
+			realtime.RequestResync()
+			return
+		}
+	}
+
+	keep := changeType != "DELETE" && isSubscriptionActive(user) && prepareSubscriber(&user)
+	if existing, ok := findSubscriber(user.AuthUserID); ok && changeType != "DELETE" && !user.SubscriptionActive {
+		deactivated.Add(existing) // Warned on the next sync
+	}
+	if keep {
+		logger.Info("subscriber updated", "notifications", len(user.Notifications))
+	} else {
+		logger.Info("subscriber removed or inactive")
+	}
+	updateSubscriber(user, keep)
+}
+
+// findSubscriber returns the indexed subscriber with the given ID
+func findSubscriber(userID string) (User, bool) {
+	for _, user := range currentSubscribers() {
+		if user.AuthUserID == userID {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+// updateSubscriber replaces (or, if keep is false, removes) a user in the
+// subscriber index and wakes the main loop
+func updateSubscriber(user User, keep bool) {
+	// Copy on write: cycles keep iterating the slice they started with
+	subscribersMu.Lock()
+	current := subscribers
+	next := make([]User, 0, len(current)+1)
+	found := false
+	for _, u := range current {
+		if u.AuthUserID == user.AuthUserID {
+			found = true
+			if keep {
+				next = append(next, user)
+			}
+			continue
+		}
+		next = append(next, u)
+	}
+	if keep && !found {
+		next = append(next, user)
+	}
+	subscribers = next
+	subscribersMu.Unlock()
+
+	realtime.changed()
+	activeSubscribers.Set(float64(len(next)))
+	pollScheduler.Sync(next, time.Now())
+
+	select {
+	case subscribersChanged <- struct{}{}:
+	default:
+	}
+}
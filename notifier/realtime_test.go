@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// realtimeUserRecord returns an unlocked_users row as Realtime sends it
+func realtimeUserRecord(id string, active bool, searchTerm string) string {
+	notifications, _ := json.Marshal([]Notification{{ID: id + "-n1", SearchTerm: searchTerm}})
+	return fmt.Sprintf(`{"auth_user_id": %q, "email": "%s@example.com", "discord_webhook_url": "https://discord.com/api/webhooks/1/abc", "discord_notifications": %s, "notifications_subscription_active": %t, "notifications_subscription_expires_at": null}`,
+		id, id, notifications, active)
+}
+
+func realtimePayload(changeType, record, oldRecord string) realtimeChange {
+	var change realtimeChange
+	payload := fmt.Sprintf(`{"data": {"type": %q, "table": "unlocked_users", "record": %s, "old_record": %s}}`, changeType, record, oldRecord)
+	if err := json.Unmarshal([]byte(payload), &change); err != nil {
+		panic(err)
+	}
+	return change
+}
+
+// useSubscribers sets the current subscribers and a poll scheduler for them,
+// restoring both after the test
+func useSubscribers(t *testing.T, users ...User) {
+	t.Helper()
+	previous, previousScheduler := subscribers, pollScheduler
+	t.Cleanup(func() { subscribers, pollScheduler = previous, previousScheduler })
+
+	subscribers = users
+	pollScheduler = NewPollScheduler(time.Minute, 15*time.Minute)
+	pollScheduler.Sync(users, time.Now())
+}
+
+func subscriberIDs() []string {
+	var ids []string
+	for _, user := range currentSubscribers() {
+		ids = append(ids, user.AuthUserID)
+	}
+	return ids
+}
+
+func TestApplyRealtimeChange(t *testing.T) {
+	existing := func() []User {
+		return []User{
+			{AuthUserID: "u1", SubscriptionActive: true, Notifications: []Notification{{ID: "u1-n1", SearchTerm: "miku"}}},
+			{AuthUserID: "u2", SubscriptionActive: true, Notifications: []Notification{{ID: "u2-n1", SearchTerm: "rin"}}},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		change     realtimeChange
+		wantIDs    []string
+		wantTerm   string // u1's search term afterwards, if u1 remains
+		wantResync bool
+	}{
+		{
+			name:     "insert adds an active subscriber",
+			change:   realtimePayload("INSERT", realtimeUserRecord("u3", true, "luka"), "null"),
+			wantIDs:  []string{"u1", "u2", "u3"},
+			wantTerm: "miku",
+		},
+		{
+			name:    "insert of an inactive user is ignored",
+			change:  realtimePayload("INSERT", realtimeUserRecord("u3", false, "luka"), "null"),
+			wantIDs: []string{"u1", "u2"},
+		},
+		{
+			name:     "update replaces the subscriber",
+			change:   realtimePayload("UPDATE", realtimeUserRecord("u1", true, "kaito"), realtimeUserRecord("u1", true, "miku")),
+			wantIDs:  []string{"u1", "u2"},
+			wantTerm: "kaito",
+		},
+		{
+			name:    "update to inactive removes the subscriber",
+			change:  realtimePayload("UPDATE", realtimeUserRecord("u1", false, "miku"), realtimeUserRecord("u1", true, "miku")),
+			wantIDs: []string{"u2"},
+		},
+		{
+			name:    "delete removes the subscriber",
+			change:  realtimePayload("DELETE", "null", realtimeUserRecord("u2", true, "rin")),
+			wantIDs: []string{"u1"},
+		},
+		{
+			name:       "delete with only the primary key requests a resync",
+			change:     realtimePayload("DELETE", "null", `{"id": 7}`),
+			wantIDs:    []string{"u1", "u2"},
+			wantResync: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useSubscribers(t, existing()...)
+			realtime.TakeResync()
+
+			applyRealtimeChange(tt.change)
+
+			if got := subscriberIDs(); !slices.Equal(got, tt.wantIDs) {
+				t.Errorf("subscribers = %v, want %v", got, tt.wantIDs)
+			}
+			if got := realtime.TakeResync(); got != tt.wantResync {
+				t.Errorf("resync requested = %v, want %v", got, tt.wantResync)
+			}
+			for _, user := range currentSubscribers() {
+				if user.AuthUserID == "u1" && tt.wantTerm != "" && user.Notifications[0].SearchTerm != tt.wantTerm {
+					t.Errorf("u1 searches %q, want %q", user.Notifications[0].SearchTerm, tt.wantTerm)
+				}
+			}
+			// Removed subscribers leave the poll schedule
+			for _, id := range []string{"u1", "u2", "u3"} {
+				_, scheduled := pollScheduler.Entry(id, id+"-n1")
+				if want := slices.Contains(tt.wantIDs, id); scheduled != want {
+					t.Errorf("%s scheduled = %v, want %v", id, scheduled, want)
+				}
+			}
+		})
+	}
+}
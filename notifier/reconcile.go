@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"discord-notifier/postgrest"
+	"discord-notifier/stripe"
+)
+
+// Stripe reconciliation settings. The stripe-webhook edge function keeps
+// unlocked_users in step with Stripe, but a missed webhook leaves a user
+// wrongly active or inactive until reconciliation corrects it.
+var (
+	stripeSecretKey         = ""
+	stripeAPIBase           = stripe.DefaultBaseURL
+	stripeReconcileInterval = 1 * time.Hour // Zero disables the periodic job
+)
+
+// stripeUser is the part of an unlocked_users row reconciled against Stripe
+type stripeUser struct {
+	AuthUserID            string  `json:"auth_user_id"`
+	Email                 string  `json:"email"`
+	StripeCustomerID      string  `json:"stripe_customer_id"`
+	StripeSubscriptionID  *string `json:"stripe_subscription_id"`
+	SubscriptionActive    bool    `json:"notifications_subscription_active"`
+	SubscriptionExpiresAt *string `json:"notifications_subscription_expires_at"`
+}
+
+var stripeUserColumns = []string{
+	"auth_user_id", "email", "stripe_customer_id", "stripe_subscription_id",
+	"notifications_subscription_active", "notifications_subscription_expires_at",
+}
+
+// stripeState is what unlocked_users should say according to Stripe
+type stripeState struct {
+	Active         bool
+	ExpiresAt      *time.Time // nil clears the expiry
+	SubscriptionID string     // empty leaves it unchanged
+}
+
+// desiredStripeState derives a user's subscription from their Stripe
+// subscriptions the way the stripe-webhook function does: active or trialing
+// grants access until the period end, a lapsed subscription keeps its period
+// end but is inactive, and a canceled one clears the expiry.
+func desiredStripeState(subs []stripe.Subscription) stripeState {
+	var best *stripe.Subscription
+	for i := range subs {
+		sub := &subs[i]
+		if sub.Active() && (best == nil || sub.CurrentPeriodEnd > best.CurrentPeriodEnd) {
+			best = sub
+		}
+	}
+	if best != nil {
+		expires := time.Unix(best.CurrentPeriodEnd, 0).UTC()
+		return stripeState{Active: true, ExpiresAt: &expires, SubscriptionID: best.ID}
+	}
+
+	// No subscription grants access; describe the newest one
+	latest := subs[0]
+	for _, sub := range subs[1:] {
+		if sub.Created > latest.Created {
+			latest = sub
+		}
+	}
+	if latest.Status == stripe.StatusCanceled {
+		return stripeState{SubscriptionID: latest.ID}
+	}
+	expires := time.Unix(latest.CurrentPeriodEnd, 0).UTC()
+	return stripeState{ExpiresAt: &expires, SubscriptionID: latest.ID}
+}
+
+// stripeDrift returns the unlocked_users columns to update so the row matches
+// want, or an empty map if it already does. Expiries within a minute of each
+// other are treated as equal.
+func stripeDrift(user stripeUser, want stripeState) map[string]interface{} {
+	update := map[string]interface{}{}
+	if user.SubscriptionActive != want.Active {
+		update["notifications_subscription_active"] = want.Active
+	}
+
+	var have *time.Time
+	if user.SubscriptionExpiresAt != nil && *user.SubscriptionExpiresAt != "" {
+		if t, err := time.Parse(time.RFC3339, *user.SubscriptionExpiresAt); err == nil {
+			have = &t
+		}
+	}
+	switch {
+	case want.ExpiresAt == nil && have != nil:
+		update["notifications_subscription_expires_at"] = nil
+	case want.ExpiresAt != nil && (have == nil || have.Sub(*want.ExpiresAt).Abs() > time.Minute):
+		update["notifications_subscription_expires_at"] = want.ExpiresAt.Format(time.RFC3339)
+	}
+
+	if want.SubscriptionID != "" && (user.StripeSubscriptionID == nil || *user.StripeSubscriptionID != want.SubscriptionID) {
+		update["stripe_subscription_id"] = want.SubscriptionID
+	}
+	return update
+}
+
+// reconcileSummary counts the outcome of a reconciliation
+type reconcileSummary struct {
+	Users, Fixed, Skipped, Failed int
+}
+
+// reconcileStripe compares every Stripe-paying user (payment_method = stripe)
+// with their Stripe subscriptions and fixes drift. Users whose Stripe customer
+// has no subscriptions are left alone, since they may have paid another way.
+// owns limits which users are reconciled; onlyUser, if set, picks one.
+func reconcileStripe(ctx context.Context, client *stripe.Client, dryRun bool, onlyUser string, owns func(userID string) bool) (reconcileSummary, error) {
+	query := db.From("unlocked_users").
+		Select(stripeUserColumns...).
+		Eq("payment_method", "stripe").
+		Not("stripe_customer_id", "is", "null").
+		Order("auth_user_id", true)
+	if onlyUser != "" {
+		query = query.Eq("auth_user_id", onlyUser)
+	}
+	users, err := postgrest.All[stripeUser](ctx, query, subscriberPageSize)
+	if err != nil {
+		return reconcileSummary{}, fmt.Errorf("failed to read Stripe users: %w", err)
+	}
+
+	var summary reconcileSummary
+	for _, user := range users {
+		if user.StripeCustomerID == "" || !owns(user.AuthUserID) {
+			continue
+		}
+		summary.Users++
+		logger := loggerFrom(ctx).With("user_id", user.AuthUserID, "email", user.Email, "stripe_customer_id", user.StripeCustomerID)
+
+		subs, err := client.ListSubscriptions(ctx, user.StripeCustomerID)
+		if err != nil {
+			logger.Warn("failed to list Stripe subscriptions", "error", err)
+			stripeReconciledUsers.WithLabelValues("error").Inc()
+			summary.Failed++
+			continue
+		}
+		if len(subs) == 0 {
+			logger.Debug("no Stripe subscriptions, leaving user as is")
+			stripeReconciledUsers.WithLabelValues("skipped").Inc()
+			summary.Skipped++
+			continue
+		}
+
+		update := stripeDrift(user, desiredStripeState(subs))
+		if len(update) == 0 {
+			stripeReconciledUsers.WithLabelValues("unchanged").Inc()
+			continue
+		}
+		if dryRun {
+			logger.Info("would fix Stripe subscription drift", "update", update)
+			summary.Fixed++
+			continue
+		}
+		if err := db.From("unlocked_users").Eq("auth_user_id", user.AuthUserID).Update(ctx, update); err != nil {
+			logger.Warn("failed to fix Stripe subscription drift", "update", update, "error", err)
+			stripeReconciledUsers.WithLabelValues("error").Inc()
+			summary.Failed++
+			continue
+		}
+		logger.Info("fixed Stripe subscription drift", "update", update)
+		stripeReconciledUsers.WithLabelValues("fixed").Inc()
+		summary.Fixed++
+	}
+	return summary, nil
+}
+
+// runStripeReconciler reconciles the users this instance checks every
+// stripeReconcileInterval, starting right away
+func runStripeReconciler(ctx context.Context) {
+	client := stripe.NewClient(stripeAPIBase, stripeSecretKey)
+	ticker := time.NewTicker(stripeReconcileInterval)
+	defer ticker.Stop()
+	for {
+		if _, held := shard.Current(); held {
+			logger := slog.With("job", "stripe_reconcile")
+			summary, err := reconcileStripe(withLogger(ctx, logger), client, false, "", ownsUser)
+			if err != nil {
+				logger.Error("Stripe reconciliation failed", "error", err)
+			} else {
+				logger.Info("Stripe reconciliation finished", "users", summary.Users,
+					"fixed", summary.Fixed, "skipped", summary.Skipped, "failed", summary.Failed)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runReconcileStripe implements the "reconcile-stripe" subcommand, which
+// reconciles every Stripe-paying user once:
+//
+//	notifier reconcile-stripe [--dry-run] [--user <auth_user_id>]
+func runReconcileStripe(args []string) {
+	fs := flag.NewFlagSet("reconcile-stripe", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "report drift without fixing it")
+	onlyUser := fs.String("user", "", "reconcile a single user (auth_user_id)")
+	fs.Parse(args)
+
+	if stripeSecretKey == "" {
+		fatal("STRIPE_SECRET_KEY is required")
+	}
+	client := stripe.NewClient(stripeAPIBase, stripeSecretKey)
+	everyone := func(string) bool { return true }
+	summary, err := reconcileStripe(context.Background(), client, *dryRun, *onlyUser, everyone)
+	if err != nil {
+		fatal("Stripe reconciliation failed", "error", err)
+	}
+	slog.Info("Stripe reconciliation finished", "dry_run", *dryRun, "users", summary.Users,
+		"fixed", summary.Fixed, "skipped", summary.Skipped, "failed", summary.Failed)
+	if summary.Failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"discord-notifier/postgrest"
+	"discord-notifier/postgrest/postgresttest"
+	"discord-notifier/stripe"
+	"discord-notifier/stripe/stripetest"
+)
+
+var (
+	periodEnd    = time.Date(2026, 11, 18, 12, 0, 0, 0, time.UTC)
+	oldPeriodEnd = time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+)
+
+// newReconcileTest points db at a fake PostgREST server holding users and
+// returns it with a stub Stripe API and a client for it
+func newReconcileTest(t *testing.T, users ...postgresttest.Row) (*postgresttest.Server, *stripetest.Server, *stripe.Client) {
+	t.Helper()
+	pg := postgresttest.NewServer()
+	t.Cleanup(pg.Close)
+	pg.SetRows("unlocked_users", users)
+
+	previous := db
+	db = postgrest.NewClient(pg.URL, "key")
+	t.Cleanup(func() { db = previous })
+
+	st := stripetest.NewServer()
+	t.Cleanup(st.Close)
+	client := stripe.NewClient(st.URL, "sk_test")
+	client.MaxRetries = 0
+	return pg, st, client
+}
+
+func stripeUserRow(id string, active bool, expiresAt *time.Time) postgresttest.Row {
+	row := postgresttest.Row{
+		"auth_user_id":                          id,
+		"email":                                 id + "@example.com",
+		"payment_method":                        "stripe",
+		"stripe_customer_id":                    "cus_" + id,
+		"stripe_subscription_id":                nil,
+		"notifications_subscription_active":     active,
+		"notifications_subscription_expires_at": nil,
+	}
+	if expiresAt != nil {
+		row["notifications_subscription_expires_at"] = expiresAt.Format(time.RFC3339)
+	}
+	return row
+}
+
+func everyone(string) bool { return true }
+
+func findRow(t *testing.T, pg *postgresttest.Server, id string) postgresttest.Row {
+	t.Helper()
+	for _, row := range pg.Rows("unlocked_users") {
+		if row["auth_user_id"] == id {
+			return row
+		}
+	}
+	t.Fatalf("no unlocked_users row for %s", id)
+	return nil
+}
+
+func TestReconcileActivatesSubscriber(t *testing.T) {
+	pg, st, client := newReconcileTest(t, stripeUserRow("u1", false, nil))
+	st.AddSubscription(stripe.Subscription{ID: "sub_1", Customer: "cus_u1", Status: stripe.StatusActive, CurrentPeriodEnd: periodEnd.Unix(), Created: 1})
+
+	summary, err := reconcileStripe(context.Background(), client, false, "", everyone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary != (reconcileSummary{Users: 1, Fixed: 1}) {
+		t.Errorf("summary = %+v, want 1 user fixed", summary)
+	}
+	row := findRow(t, pg, "u1")
+	if row["notifications_subscription_active"] != true {
+		t.Errorf("active = %v, want true", row["notifications_subscription_active"])
+	}
+	if row["notifications_subscription_expires_at"] != periodEnd.Format(time.RFC3339) {
+		t.Errorf("expires_at = %v, want %s", row["notifications_subscription_expires_at"], periodEnd.Format(time.RFC3339))
+	}
+	if row["stripe_subscription_id"] != "sub_1" {
+		t.Errorf("stripe_subscription_id = %v, want sub_1", row["stripe_subscription_id"])
+	}
+}
+
+func TestReconcileDeactivatesCanceledSubscriber(t *testing.T) {
+	pg, st, client := newReconcileTest(t, stripeUserRow("u1", true, &periodEnd))
+	st.AddSubscription(stripe.Subscription{ID: "sub_1", Customer: "cus_u1", Status: stripe.StatusCanceled, CurrentPeriodEnd: periodEnd.Unix(), Created: 1})
+
+	if _, err := reconcileStripe(context.Background(), client, false, "", everyone); err != nil {
+		t.Fatal(err)
+	}
+	row := findRow(t, pg, "u1")
+	if row["notifications_subscription_active"] != false {
+		t.Errorf("active = %v, want false", row["notifications_subscription_active"])
+	}
+	if row["notifications_subscription_expires_at"] != nil {
+		t.Errorf("expires_at = %v, want it cleared", row["notifications_subscription_expires_at"])
+	}
+}
+
+func TestReconcileUpdatesExpiry(t *testing.T) {
+	pg, st, client := newReconcileTest(t, stripeUserRow("u1", true, &oldPeriodEnd))
+	st.AddSubscription(stripe.Subscription{ID: "sub_1", Customer: "cus_u1", Status: stripe.StatusActive, CurrentPeriodEnd: periodEnd.Unix(), Created: 1})
+
+	summary, err := reconcileStripe(context.Background(), client, false, "", everyone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Fixed != 1 {
+		t.Errorf("summary = %+v, want 1 user fixed", summary)
+	}
+	row := findRow(t, pg, "u1")
+	if row["notifications_subscription_active"] != true {
+		t.Errorf("active = %v, want true", row["notifications_subscription_active"])
+	}
+	if row["notifications_subscription_expires_at"] != periodEnd.Format(time.RFC3339) {
+		t.Errorf("expires_at = %v, want %s", row["notifications_subscription_expires_at"], periodEnd.Format(time.RFC3339))
+	}
+
+	// A second run finds nothing left to fix
+	summary, err = reconcileStripe(context.Background(), client, false, "", everyone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Fixed != 0 {
+		t.Errorf("second run summary = %+v, want nothing fixed", summary)
+	}
+}
+
+func TestReconcileSkipsUsersWithoutStripeSubscriptions(t *testing.T) {
+	noCustomer := stripeUserRow("u1", true, nil)
+	noCustomer["stripe_customer_id"] = nil
+	pg, st, client := newReconcileTest(t, noCustomer, stripeUserRow("u2", true, &periodEnd))
+
+	summary, err := reconcileStripe(context.Background(), client, false, "", everyone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary != (reconcileSummary{Users: 1, Skipped: 1}) {
+		t.Errorf("summary = %+v, want u2 skipped and u1 not counted", summary)
+	}
+	for _, r := range st.Requests() {
+		if customer := r.URL.Query().Get("customer"); customer != "cus_u2" {
+			t.Errorf("listed subscriptions of %q, want only cus_u2", customer)
+		}
+	}
+	if row := findRow(t, pg, "u2"); row["notifications_subscription_active"] != true ||
+		row["notifications_subscription_expires_at"] != periodEnd.Format(time.RFC3339) {
+		t.Errorf("u2 = %v, want it untouched", row)
+	}
+}
+
+func TestReconcileLeavesRowsOnStripeError(t *testing.T) {
+	pg, st, client := newReconcileTest(t, stripeUserRow("u1", false, nil))
+	st.AddSubscription(stripe.Subscription{ID: "sub_1", Customer: "cus_u1", Status: stripe.StatusActive, CurrentPeriodEnd: periodEnd.Unix(), Created: 1})
+	st.FailNext(http.StatusInternalServerError)
+
+	summary, err := reconcileStripe(context.Background(), client, false, "", everyone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary != (reconcileSummary{Users: 1, Failed: 1}) {
+		t.Errorf("summary = %+v, want 1 user failed", summary)
+	}
+	row := findRow(t, pg, "u1")
+	if row["notifications_subscription_active"] != false || row["notifications_subscription_expires_at"] != nil {
+		t.Errorf("u1 = %v, want it untouched", row)
+	}
+	for _, r := range pg.Requests() {
+		if r.Method != http.MethodGet {
+			t.Errorf("sent %s to PostgREST, want no writes", r.Method)
+		}
+	}
+}
+
+func TestReconcileDryRunWritesNothing(t *testing.T) {
+	pg, st, client := newReconcileTest(t, stripeUserRow("u1", false, nil))
+	st.AddSubscription(stripe.Subscription{ID: "sub_1", Customer: "cus_u1", Status: stripe.StatusActive, CurrentPeriodEnd: periodEnd.Unix(), Created: 1})
+
+	summary, err := reconcileStripe(context.Background(), client, true, "", everyone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Fixed != 1 {
+		t.Errorf("summary = %+v, want 1 user reported", summary)
+	}
+	if row := findRow(t, pg, "u1"); row["notifications_subscription_active"] != false {
+		t.Errorf("u1 = %v, want it untouched", row)
+	}
+}
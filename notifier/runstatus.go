@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"discord-notifier/postgrest"
+)
+
+// Categories recorded when a notification check is skipped or fails
+const (
+	runErrNoSupportedMarkets = "no_supported_markets"
+	runErrNoSendicoMarkets   = "no_sendico_markets"
+	runErrNoSearchTerms      = "no_search_terms"
+	runErrTranslation        = "translation_failed"
+	runErrNoWebhooks         = "no_webhooks"
+	runErrWebhook            = "webhook_failed"
+	runErrTierLimit          = "over_tier_limit"
+	runErrTemplate           = "invalid_template"
+	runErrMentions           = "invalid_mentions"
+)
+
+// notificationRun is the outcome of the last check of a notification
+type notificationRun struct {
+	UserID          string    `json:"user_id"`
+	NotificationID  string    `json:"notification_id"`
+	CheckedAt       time.Time `json:"checked_at"`
+	ErrorCategory   string    `json:"error_category,omitempty"`
+	Error           string    `json:"error,omitempty"`
+	TranslatedTerm  string    `json:"translated_term,omitempty"`
+	Terms           []string  `json:"terms,omitempty"`
+	ItemsFound      int       `json:"items_found"`
+	NewItems        int       `json:"new_items"`
+	WebhooksSent    int       `json:"webhooks_sent"`
+	WebhooksFailed  int       `json:"webhooks_failed"`
+	WebhooksInvalid int       `json:"webhooks_invalid"`
+}
+
+// runRegistry keeps the last run of every notification, keyed by pollKey,
+// and which runs haven't been written to notification_status yet
+type runRegistry struct {
+	mu    sync.RWMutex
+	runs  map[string]notificationRun
+	dirty map[string]bool
+}
+
+var notificationRuns = &runRegistry{runs: make(map[string]notificationRun), dirty: make(map[string]bool)}
+
+func (r *runRegistry) Record(run notificationRun) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := pollKey(run.UserID, run.NotificationID)
+	r.runs[key] = run
+	r.dirty[key] = true
+}
+
+// TakeUnsaved returns the runs recorded since the last call and marks them saved
+func (r *runRegistry) TakeUnsaved() []notificationRun {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	runs := make([]notificationRun, 0, len(r.dirty))
+	for key := range r.dirty {
+		runs = append(runs, r.runs[key])
+	}
+	clear(r.dirty)
+	return runs
+}
+
+// MarkUnsaved returns runs that failed to save to the unsaved set, unless a
+// newer run of the same notification has been recorded since
+func (r *runRegistry) MarkUnsaved(runs []notificationRun) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, run := range runs {
+		key := pollKey(run.UserID, run.NotificationID)
+		if current, ok := r.runs[key]; ok && current.CheckedAt.Equal(run.CheckedAt) {
+			r.dirty[key] = true
+		}
+	}
+}
+
+func (r *runRegistry) Get(userID, notificationID string) (notificationRun, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	run, ok := r.runs[pollKey(userID, notificationID)]
+	return run, ok
+}
+
+// notificationStatusRow is a row of the notification_status table
+type notificationStatusRow struct {
+	AuthUserID      string   `json:"auth_user_id"`
+	NotificationID  string   `json:"notification_id"`
+	CheckedAt       string   `json:"checked_at"`
+	ErrorCategory   string   `json:"error_category"`
+	Error           string   `json:"error"`
+	TranslatedTerm  string   `json:"translated_term"`
+	Terms           []string `json:"terms"`
+	ItemsFound      int      `json:"items_found"`
+	NewItems        int      `json:"new_items"`
+	WebhooksSent    int      `json:"webhooks_sent"`
+	WebhooksFailed  int      `json:"webhooks_failed"`
+	WebhooksInvalid int      `json:"webhooks_invalid"`
+}
+
+func newNotificationStatusRow(run notificationRun) notificationStatusRow {
+	return notificationStatusRow{
+		AuthUserID:      run.UserID,
+		NotificationID:  run.NotificationID,
+		CheckedAt:       run.CheckedAt.UTC().Format(time.RFC3339),
+		ErrorCategory:   run.ErrorCategory,
+		Error:           run.Error,
+		TranslatedTerm:  run.TranslatedTerm,
+		Terms:           nonNil(run.Terms),
+		ItemsFound:      run.ItemsFound,
+		NewItems:        run.NewItems,
+		WebhooksSent:    run.WebhooksSent,
+		WebhooksFailed:  run.WebhooksFailed,
+		WebhooksInvalid: run.WebhooksInvalid,
+	}
+}
+
+// saveRunStatuses writes the runs recorded since the last save to
+// notification_status in one request. Runs that fail to save are retried
+// with the next save.
+func saveRunStatuses(ctx context.Context) {
+	if statusDisabled.Load() {
+		return
+	}
+	runs := notificationRuns.TakeUnsaved()
+	if len(runs) == 0 {
+		return
+	}
+
+	rows := make([]notificationStatusRow, 0, len(runs))
+	for _, run := range runs {
+		rows = append(rows, newNotificationStatusRow(run))
+	}
+
+	err := db.From("notification_status").Upsert(ctx, rows, "auth_user_id,notification_id")
+	var apiErr *postgrest.Error
+	switch {
+	case err == nil:
+		loggerFrom(ctx).Debug("saved notification status", "notifications", len(rows))
+	case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound:
+		if !statusDisabled.Swap(true) {
+			loggerFrom(ctx).Warn("notification_status table missing, no longer saving status; run the Supabase migrations", "error", err)
+		}
+	default:
+		notificationRuns.MarkUnsaved(runs)
+		loggerFrom(ctx).Warn("failed to save notification status", "notifications", len(rows), "error", err)
+	}
+}
+
+// statusDisabled stops run statuses from being written to notification_status.
+// It is set by NOTIFICATION_STATUS=false, or when the table doesn't exist.
+var statusDisabled atomic.Bool
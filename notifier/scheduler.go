@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	maxSlowdown   = 16.0 // Cap on adaptive slowdown after repeated 429s
+	slowdownDecay = 0.9  // Multiplier applied to the slowdown after each success
+)
+
+// tokenBucket is a simple token bucket. Its effective rate is divided by
+// slowdown, which grows when Sendico rate limits us and decays on success.
+type tokenBucket struct {
+	rate     float64 // tokens per second
+	burst    float64
+	tokens   float64
+	last     time.Time
+	slowdown float64
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:     rate,
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		last:     now,
+		slowdown: 1,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	b.last = now
+	b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate/b.slowdown)
+}
+
+// delay returns how long until a token is available (0 if available now)
+func (b *tokenBucket) delay(now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	missing := 1 - b.tokens
+	d := time.Duration(missing / (b.rate / b.slowdown) * float64(time.Second))
+	if d < time.Millisecond {
+		d = time.Millisecond
+	}
+	return d
+}
+
+func (b *tokenBucket) take() {
+	b.tokens--
+}
+
+// schedTicket is a request waiting for permission to run
+type schedTicket struct {
+	ready chan struct{}
+}
+
+// schedQueueKey identifies the requests one owner has waiting for one shop
+type schedQueueKey struct {
+	owner string
+	shop  SendicoShop
+}
+
+// schedQueue holds an owner's waiting requests for a shop in FIFO order
+type schedQueue struct {
+	key     schedQueueKey
+	tickets []*schedTicket
+}
+
+// RequestScheduler enforces a global requests-per-second budget for Sendico
+// with stricter per-shop sub-limits. Waiting requests are queued per owner
+// (user) and shop, and queues are granted round-robin, so one user with many
+// searches can't starve others and a shop out of tokens doesn't hold up
+// requests to other shops.
+type RequestScheduler struct {
+	mu     sync.Mutex
+	now    func() time.Time
+	global *tokenBucket
+	shops  map[SendicoShop]*tokenBucket
+	queues map[schedQueueKey]*schedQueue
+	order  []*schedQueue // non-empty queues, in round-robin order
+	next   int
+	wake   chan struct{}
+}
+
+// NewRequestScheduler creates a scheduler allowing rps requests per second
+// overall, with optional per-shop limits
+func NewRequestScheduler(rps float64, burst int, shopRPS map[SendicoShop]float64) *RequestScheduler {
+	s := newRequestScheduler(rps, burst, shopRPS, time.Now)
+	go s.dispatch()
+	return s
+}
+
+// newRequestScheduler creates a scheduler without starting its dispatcher
+func newRequestScheduler(rps float64, burst int, shopRPS map[SendicoShop]float64, now func() time.Time) *RequestScheduler {
+	s := &RequestScheduler{
+		now:    now,
+		global: newTokenBucket(rps, burst, now()),
+		shops:  make(map[SendicoShop]*tokenBucket),
+		queues: make(map[schedQueueKey]*schedQueue),
+		wake:   make(chan struct{}, 1),
+	}
+	for shop, rate := range shopRPS {
+		s.shops[shop] = newTokenBucket(rate, 1, now())
+	}
+	return s
+}
+
+// Wait blocks until a request for shop (empty for non-search requests) may be
+// sent on behalf of owner, or ctx is done
+func (s *RequestScheduler) Wait(ctx context.Context, shop SendicoShop, owner string) error {
+	t := s.enqueue(owner, shop)
+	s.signal()
+
+	select {
+	case <-t.ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		select {
+		case <-t.ready:
+			// Granted while we were cancelling; the token is spent either way
+		default:
+			s.removeTicket(schedQueueKey{owner: owner, shop: shop}, t)
+		}
+		return ctx.Err()
+	}
+}
+
+// enqueue adds a ticket to the back of the owner's queue for shop
+func (s *RequestScheduler) enqueue(owner string, shop SendicoShop) *schedTicket {
+	t := &schedTicket{ready: make(chan struct{})}
+	key := schedQueueKey{owner: owner, shop: shop}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, ok := s.queues[key]
+	if !ok {
+		q = &schedQueue{key: key}
+		s.queues[key] = q
+		s.order = append(s.order, q)
+	}
+	q.tickets = append(q.tickets, t)
+	return t
+}
+
+// Throttled records a 429 from shop and halves its effective rate. Other
+// shops keep their rate.
+func (s *RequestScheduler) Throttled(shop SendicoShop) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	bucket, ok := s.shops[shop]
+	if !ok {
+		// Give the shop a bucket of its own at the global rate to slow down
+		bucket = newTokenBucket(s.global.rate, int(s.global.burst), now)
+		s.shops[shop] = bucket
+	}
+	bucket.refill(now)
+	bucket.slowdown = math.Min(maxSlowdown, bucket.slowdown*2)
+	slog.Warn("slowing down Sendico requests after rate limit", "shop", shopLabel(shop), "slowdown", bucket.slowdown)
+}
+
+// Succeeded records a successful request, gradually restoring the rate
+func (s *RequestScheduler) Succeeded(shop SendicoShop) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if bucket, ok := s.shops[shop]; ok && bucket.slowdown > 1 {
+		bucket.refill(s.now())
+		bucket.slowdown = math.Max(1, bucket.slowdown*slowdownDecay)
+	}
+}
+
+func (s *RequestScheduler) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// dispatch grants queued tickets as tokens become available
+func (s *RequestScheduler) dispatch() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		wait := s.grant()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if wait > 0 {
+			timer.Reset(wait)
+		}
+
+		select {
+		case <-s.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// grant hands out as many tokens as possible and returns how long to wait
+// before trying again (0 if nothing is queued)
+func (s *RequestScheduler) grant() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		if len(s.order) == 0 {
+			return 0
+		}
+
+		now := s.now()
+		globalDelay := s.global.delay(now)
+		if globalDelay > 0 {
+			return globalDelay
+		}
+
+		// Find the next queue (round-robin) whose shop has a token
+		var minDelay time.Duration = -1
+		granted := false
+		for i := 0; i < len(s.order); i++ {
+			idx := (s.next + i) % len(s.order)
+			q := s.order[idx]
+
+			if shopBucket, ok := s.shops[q.key.shop]; ok {
+				if d := shopBucket.delay(now); d > 0 {
+					if minDelay < 0 || d < minDelay {
+						minDelay = d
+					}
+					continue
+				}
+				shopBucket.take()
+			}
+			s.global.take()
+			close(q.tickets[0].ready)
+
+			q.tickets = q.tickets[1:]
+			if len(q.tickets) == 0 {
+				s.next = idx
+				s.removeQueue(idx)
+			} else {
+				s.next = (idx + 1) % len(s.order)
+			}
+			granted = true
+			break
+		}
+
+		if !granted {
+			return minDelay
+		}
+	}
+}
+
+// removeTicket drops a cancelled ticket from its queue. Caller holds s.mu.
+func (s *RequestScheduler) removeTicket(key schedQueueKey, t *schedTicket) {
+	q, ok := s.queues[key]
+	if !ok {
+		return
+	}
+	for i, queued := range q.tickets {
+		if queued != t {
+			continue
+		}
+		q.tickets = append(q.tickets[:i], q.tickets[i+1:]...)
+		if len(q.tickets) == 0 {
+			for idx, ordered := range s.order {
+				if ordered == q {
+					s.removeQueue(idx)
+					break
+				}
+			}
+		}
+		return
+	}
+}
+
+// removeQueue drops the empty queue at idx from the rotation, leaving next
+// on the queue that followed it. Caller holds s.mu.
+func (s *RequestScheduler) removeQueue(idx int) {
+	delete(s.queues, s.order[idx].key)
+	s.order = append(s.order[:idx], s.order[idx+1:]...)
+	if s.next > idx {
+		s.next--
+	}
+	if len(s.order) > 0 {
+		s.next %= len(s.order)
+	} else {
+		s.next = 0
+	}
+}
+
+type requestOwnerKey struct{}
+
+// withRequestOwner tags Sendico requests made with ctx as belonging to owner,
+// which the scheduler uses for fairness
+func withRequestOwner(ctx context.Context, owner string) context.Context {
+	return context.WithValue(ctx, requestOwnerKey{}, owner)
+}
+
+func requestOwner(ctx context.Context) string {
+	if owner, ok := ctx.Value(requestOwnerKey{}).(string); ok {
+		return owner
+	}
+	return ""
+}
+
+func shopLabel(shop SendicoShop) string {
+	if shop == "" {
+		return "Sendico"
+	}
+	return string(shop)
+}
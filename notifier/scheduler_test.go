@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func granted(t *schedTicket) bool {
+	select {
+	case <-t.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	clock := newFakeClock()
+	bucket := newTokenBucket(2, 3, clock.Now())
+
+	for i := 0; i < 3; i++ {
+		if d := bucket.delay(clock.Now()); d != 0 {
+			t.Fatalf("delay with %d of 3 burst tokens used = %v, want 0", i, d)
+		}
+		bucket.take()
+	}
+	if d := bucket.delay(clock.Now()); d != 500*time.Millisecond {
+		t.Errorf("delay after burst = %v, want 500ms at 2/s", d)
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	if d := bucket.delay(clock.Now()); d != 0 {
+		t.Errorf("delay after refilling = %v, want 0", d)
+	}
+	bucket.take()
+
+	bucket.slowdown = 2
+	if d := bucket.delay(clock.Now()); d != time.Second {
+		t.Errorf("delay slowed down 2x = %v, want 1s", d)
+	}
+
+	// Refilling never exceeds the burst
+	clock.Advance(time.Hour)
+	bucket.refill(clock.Now())
+	if bucket.tokens != 3 {
+		t.Errorf("tokens after an hour = %v, want burst of 3", bucket.tokens)
+	}
+}
+
+func TestRequestSchedulerRoundRobinAcrossOwners(t *testing.T) {
+	clock := newFakeClock()
+	s := newRequestScheduler(1, 1, nil, clock.Now)
+
+	a1 := s.enqueue("a", SendicoMercari)
+	a2 := s.enqueue("a", SendicoMercari)
+	a3 := s.enqueue("a", SendicoMercari)
+	b1 := s.enqueue("b", SendicoMercari)
+
+	want := []*schedTicket{a1, b1, a2, a3}
+	names := map[*schedTicket]string{a1: "a1", a2: "a2", a3: "a3", b1: "b1"}
+	for i, next := range want {
+		wait := s.grant()
+		for _, ticket := range want[:i+1] {
+			if !granted(ticket) {
+				t.Fatalf("grant %d: %s not granted", i+1, names[ticket])
+			}
+		}
+		for _, ticket := range want[i+1:] {
+			if granted(ticket) {
+				t.Fatalf("grant %d: %s granted before %s", i+1, names[ticket], names[next])
+			}
+		}
+		if i < len(want)-1 && wait != time.Second {
+			t.Fatalf("grant %d: wait = %v, want 1s", i+1, wait)
+		}
+		clock.Advance(wait)
+	}
+	if wait := s.grant(); wait != 0 {
+		t.Errorf("wait with nothing queued = %v, want 0", wait)
+	}
+}
+
+func TestRequestSchedulerShopLimitDoesNotBlockOtherShops(t *testing.T) {
+	clock := newFakeClock()
+	s := newRequestScheduler(10, 10, map[SendicoShop]float64{SendicoRakuten: 0.5}, clock.Now)
+
+	rakuten1 := s.enqueue("a", SendicoRakuten)
+	rakuten2 := s.enqueue("a", SendicoRakuten)
+	mercari := s.enqueue("a", SendicoMercari)
+
+	if wait := s.grant(); wait != 2*time.Second {
+		t.Errorf("wait = %v, want 2s for Rakuten's next token", wait)
+	}
+	if !granted(rakuten1) || !granted(mercari) {
+		t.Fatal("first Rakuten request and the Mercari request not granted")
+	}
+	if granted(rakuten2) {
+		t.Fatal("second Rakuten request granted over the shop's limit")
+	}
+
+	clock.Advance(2 * time.Second)
+	s.grant()
+	if !granted(rakuten2) {
+		t.Error("second Rakuten request not granted once the shop's token refilled")
+	}
+}
+
+func TestRequestSchedulerThrottledSlowsOnlyThatShop(t *testing.T) {
+	clock := newFakeClock()
+	s := newRequestScheduler(1, 1, nil, clock.Now)
+
+	s.Throttled(SendicoMercari)
+	s.Throttled(SendicoMercari)
+	if s.global.slowdown != 1 {
+		t.Errorf("global slowdown = %v, want 1", s.global.slowdown)
+	}
+	if got := s.shops[SendicoMercari].slowdown; got != 4 {
+		t.Errorf("mercari slowdown = %v, want 4", got)
+	}
+
+	first := s.enqueue("a", SendicoMercari)
+	s.grant()
+	if !granted(first) {
+		t.Fatal("first Mercari request not granted")
+	}
+
+	// Mercari now earns a token every 4s, Rakuma still every second
+	clock.Advance(time.Second)
+	second := s.enqueue("a", SendicoMercari)
+	rakuma := s.enqueue("a", SendicoRakuma)
+	s.grant()
+	if !granted(rakuma) || granted(second) {
+		t.Fatalf("after 1s: rakuma granted %v, mercari granted %v, want only rakuma", granted(rakuma), granted(second))
+	}
+
+	clock.Advance(time.Second)
+	if wait := s.grant(); wait != 2*time.Second || granted(second) {
+		t.Fatalf("after 2s: wait %v, mercari granted %v, want 2s and not granted", wait, granted(second))
+	}
+	clock.Advance(2 * time.Second)
+	s.grant()
+	if !granted(second) {
+		t.Fatal("mercari not granted after its slowed-down refill")
+	}
+
+	for i := 0; i < 20; i++ {
+		s.Succeeded(SendicoMercari)
+	}
+	if got := s.shops[SendicoMercari].slowdown; got != 1 {
+		t.Errorf("mercari slowdown after successes = %v, want back to 1", got)
+	}
+	for i := 0; i < 10; i++ {
+		s.Throttled(SendicoMercari)
+	}
+	if got := s.shops[SendicoMercari].slowdown; got != maxSlowdown {
+		t.Errorf("mercari slowdown after many 429s = %v, want capped at %v", got, maxSlowdown)
+	}
+}
+
+func TestRequestSchedulerWaitCancelled(t *testing.T) {
+	clock := newFakeClock()
+	s := newRequestScheduler(1, 1, nil, clock.Now)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.Wait(ctx, SendicoMercari, "a"); err != context.Canceled {
+		t.Fatalf("Wait() = %v, want context.Canceled", err)
+	}
+	if len(s.order) != 0 || len(s.queues) != 0 {
+		t.Errorf("cancelled request still queued: %d queue(s)", len(s.order))
+	}
+}
@@ -7,16 +7,17 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	orderedmap "github.com/wk8/go-ordered-map/v2"
-	"golang.org/x/sync/errgroup"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ErrHMACRefreshNeeded is returned when HMAC secret needs to be refreshed
@@ -31,12 +32,17 @@ type SendicoClient struct {
 	mu         sync.RWMutex
 	hmacSecret string
 	baseURL    string
+	scheduler  *RequestScheduler
+
+	// When the HMAC secret was last fetched or used in a successful request
+	hmacVerifiedAt time.Time
 }
 
-func NewSendicoClient() (*SendicoClient, error) {
+func NewSendicoClient(scheduler *RequestScheduler) (*SendicoClient, error) {
 	client := &SendicoClient{
 		httpClient: http.DefaultClient,
 		baseURL:    SendicoBaseURL,
+		scheduler:  scheduler,
 	}
 
 	ctx := context.Background()
@@ -107,6 +113,7 @@ func (c *SendicoClient) FindHMAC(ctx context.Context) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.hmacSecret = newSecret
+	c.hmacVerifiedAt = time.Now()
 	return nil
 }
 
@@ -116,13 +123,26 @@ func (c *SendicoClient) HMACSecret() string {
 	return c.hmacSecret
 }
 
+// HMACVerifiedAt returns when the HMAC secret was last known to be good
+func (c *SendicoClient) HMACVerifiedAt() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.hmacVerifiedAt
+}
+
+// Translate translates an English search term to Japanese
 func (c *SendicoClient) Translate(ctx context.Context, text string) (string, error) {
+	return c.TranslateText(ctx, text, "en", "ja")
+}
+
+// TranslateText translates text between two languages, e.g. "ja" to "en"
+func (c *SendicoClient) TranslateText(ctx context.Context, text, from, to string) (string, error) {
 	path := "/api/translate"
 
 	request := orderedmap.New[string, any]()
-	request.Set("from", "en")
+	request.Set("from", from)
 	request.Set("string", text)
-	request.Set("to", "ja")
+	request.Set("to", to)
 
 	requestJSON, err := json.Marshal(request)
 	if err != nil {
@@ -139,7 +159,7 @@ func (c *SendicoClient) Translate(ctx context.Context, text string) (string, err
 		return "", err
 	}
 
-	resp, err := c.req(ctx, http.MethodPost, path, bytes.NewReader(requestJSON), hmac, func(req *http.Request) {
+	resp, err := c.req(ctx, "", http.MethodPost, path, bytes.NewReader(requestJSON), hmac, func(req *http.Request) {
 		req.Header.Set("Content-Type", "application/json")
 	})
 	if err != nil {
@@ -155,7 +175,7 @@ func (c *SendicoClient) Translate(ctx context.Context, text string) (string, err
 				return "", err
 			}
 			// Retry with new HMAC
-			resp, err = c.req(ctx, http.MethodPost, path, bytes.NewReader(requestJSON), hmac, func(req *http.Request) {
+			resp, err = c.req(ctx, "", http.MethodPost, path, bytes.NewReader(requestJSON), hmac, func(req *http.Request) {
 				req.Header.Set("Content-Type", "application/json")
 			})
 			if err != nil {
@@ -239,7 +259,7 @@ func (c *SendicoClient) Search(ctx context.Context, shop SendicoShop, opts Sendi
 		return nil, err
 	}
 
-	resp, err := c.req(ctx, http.MethodGet, path.String(), nil, hmac, func(req *http.Request) {
+	resp, err := c.req(ctx, shop, http.MethodGet, path.String(), nil, hmac, func(req *http.Request) {
 		req.Header.Set("Content-Type", "application/json")
 	})
 	if err != nil {
@@ -255,7 +275,7 @@ func (c *SendicoClient) Search(ctx context.Context, shop SendicoShop, opts Sendi
 				return nil, err
 			}
 			// Retry with new HMAC
-			resp, err = c.req(ctx, http.MethodGet, path.String(), nil, hmac, func(req *http.Request) {
+			resp, err = c.req(ctx, shop, http.MethodGet, path.String(), nil, hmac, func(req *http.Request) {
 				req.Header.Set("Content-Type", "application/json")
 			})
 			if err != nil {
@@ -283,55 +303,20 @@ func (c *SendicoClient) Search(ctx context.Context, shop SendicoShop, opts Sendi
 	return response.Data.Items, nil
 }
 
-func (c *SendicoClient) BulkSearch(ctx context.Context, shops []SendicoShop, opts SendicoSearchOptions) ([]SendicoItem, error) {
-	items := make([]SendicoItem, 0)
-	itemsMu := sync.Mutex{}
-
-	// Reduced concurrency and increased delay to avoid rate limiting
-	// Sendico API appears to have strict rate limits, especially for Rakuten
-	maxConcurrent := 3 // Reduced from 5 to 3
-	requestDelay := 1 * time.Second // Increased from 200ms to 1s between requests
-	sem := make(chan struct{}, maxConcurrent)
-	g := new(errgroup.Group)
-	
-	for i, shop := range shops {
-		shop := shop
-		i := i
-		g.Go(func() error {
-			// Acquire semaphore
-			sem <- struct{}{}
-			defer func() { <-sem }()
-			
-			// Increased delay between requests to respect rate limits
-			if i > 0 {
-				time.Sleep(requestDelay)
-			}
-			
-			results, err := c.Search(ctx, shop, opts)
-			if err != nil {
-				// Check if it's a rate limit error
-				if errStr := err.Error(); strings.Contains(errStr, "429") || strings.Contains(errStr, "rate limited") {
-					log.Printf("   ❌ Rate limited searching %s: %v", shop, err)
-				} else {
-					log.Printf("   ⚠️  Error searching %s: %v", shop, err)
-				}
-				return nil // Continue with other shops
-			}
+func (c *SendicoClient) req(ctx context.Context, shop SendicoShop, method, path string, body io.Reader, hmac *HMACAttributes, opts ...func(*http.Request)) (res *http.Response, err error) {
+	ctx, span := tracer.Start(ctx, "sendico.request", trace.WithAttributes(
+		attribute.String("http.request.method", method),
+		attribute.String("url.path", strings.SplitN(path, "?", 2)[0]),
+		attribute.String("sendico.shop", string(shop)),
+	))
+	defer func() { endSpan(span, err) }()
 
-			itemsMu.Lock()
-			defer itemsMu.Unlock()
-			items = append(items, results...)
-			return nil
-		})
-	}
-
-	_ = g.Wait() // Ignore errors, we log them above
-	return items, nil
-}
-
-func (c *SendicoClient) req(ctx context.Context, method, path string, body io.Reader, hmac *HMACAttributes, opts ...func(*http.Request)) (*http.Response, error) {
 	maxRetries := 3
 	baseDelay := 2 * time.Second
+	logger := loggerFrom(ctx).With("path", path)
+	if shop != "" {
+		logger = logger.With("shop", shop)
+	}
 	
 	// Read body into bytes once for retries (body can only be read once)
 	var bodyBytes []byte
@@ -344,6 +329,13 @@ func (c *SendicoClient) req(ctx context.Context, method, path string, body io.Re
 	}
 	
 	for attempt := 0; attempt <= maxRetries; attempt++ {
+		// Wait for the global rate limiter before every attempt, including retries
+		if c.scheduler != nil {
+			if err := c.scheduler.Wait(ctx, shop, requestOwner(ctx)); err != nil {
+				return nil, err
+			}
+		}
+
 		req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, func() io.Reader {
 			if bodyBytes != nil {
 				return bytes.NewReader(bodyBytes)
@@ -366,14 +358,17 @@ func (c *SendicoClient) req(ctx context.Context, method, path string, body io.Re
 
 		res, err := c.httpClient.Do(req)
 		if err != nil {
+			sendicoResponses.WithLabelValues(metricShopLabel(shop), "error").Inc()
 			if attempt < maxRetries {
 				delay := baseDelay * time.Duration(1<<uint(attempt)) // Exponential backoff: 2s, 4s, 8s
-				log.Printf("   ⚠️  Request error (attempt %d/%d), retrying in %v: %v", attempt+1, maxRetries+1, delay, err)
+				logger.Warn("sendico request error, retrying", "attempt", attempt+1, "max_attempts", maxRetries+1, "delay", delay, "error", err)
 				time.Sleep(delay)
 				continue
 			}
 			return nil, err
 		}
+		sendicoResponses.WithLabelValues(metricShopLabel(shop), strconv.Itoa(res.StatusCode)).Inc()
+		span.SetAttributes(attribute.Int("http.response.status_code", res.StatusCode), attribute.Int("sendico.attempts", attempt+1))
 
 		// Handle 403 (Forbidden/Access Denied) - HMAC secret may have expired
 		if res.StatusCode == http.StatusForbidden {
@@ -384,12 +379,14 @@ func (c *SendicoClient) req(ctx context.Context, method, path string, body io.Re
 			// Check if it's an access denied error (HMAC expired)
 			if strings.Contains(bodyStr, "Access denied") || strings.Contains(bodyStr, "403") {
 				// Refresh HMAC secret - it may have expired
-				log.Printf("   🔄 Access denied (403) - refreshing HMAC secret...")
+				logger.Info("access denied (403), refreshing HMAC secret")
 				if err := c.FindHMAC(ctx); err != nil {
-					log.Printf("   ❌ Failed to refresh HMAC secret: %v", err)
+					logger.Error("failed to refresh HMAC secret", "error", err)
+					hmacRefreshes.WithLabelValues("error").Inc()
 					return nil, fmt.Errorf("failed to refresh HMAC secret: %w", err)
 				}
-				log.Printf("   ✅ HMAC secret refreshed")
+				logger.Info("HMAC secret refreshed")
+				hmacRefreshes.WithLabelValues("success").Inc()
 				
 				// Return special error so caller can rebuild HMAC with new secret and retry
 				// We can't rebuild HMAC here because we don't have the payload
@@ -397,13 +394,16 @@ func (c *SendicoClient) req(ctx context.Context, method, path string, body io.Re
 			}
 			
 			// Other 403 errors (not access denied)
-			log.Printf("   ⚠️  Sendico API error: status 403, body: %s", bodyStr)
+			logger.Warn("sendico API error", "status", res.StatusCode, "body", bodyStr)
 			return nil, fmt.Errorf("access denied (403): %s", bodyStr)
 		}
 
 		// Handle 429 (Too Many Requests) with retry
 		if res.StatusCode == http.StatusTooManyRequests {
 			_ = res.Body.Close()
+			if c.scheduler != nil {
+				c.scheduler.Throttled(shop)
+			}
 			
 			// Check for Retry-After header
 			retryAfter := baseDelay
@@ -419,7 +419,7 @@ func (c *SendicoClient) req(ctx context.Context, method, path string, body io.Re
 			}
 			
 			if attempt < maxRetries {
-				log.Printf("   ⚠️  Rate limited (429) on %s (attempt %d/%d), retrying in %v", path, attempt+1, maxRetries+1, retryAfter)
+				logger.Warn("rate limited (429), retrying", "attempt", attempt+1, "max_attempts", maxRetries+1, "delay", retryAfter)
 				time.Sleep(retryAfter)
 				continue
 			}
@@ -430,11 +430,19 @@ func (c *SendicoClient) req(ctx context.Context, method, path string, body io.Re
 
 		if res.StatusCode != http.StatusOK {
 			body, _ := io.ReadAll(res.Body)
-			log.Printf("   ⚠️  Sendico API error: status %d, body: %s", res.StatusCode, string(body))
+			logger.Warn("sendico API error", "status", res.StatusCode, "body", string(body))
 			_ = res.Body.Close()
 			return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
 		}
 
+		if c.scheduler != nil {
+			c.scheduler.Succeeded(shop)
+		}
+		if hmac != nil {
+			c.mu.Lock()
+			c.hmacVerifiedAt = time.Now()
+			c.mu.Unlock()
+		}
 		return res, nil
 	}
 	
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Sharding splits subscribers between notifier instances so replicas don't
+// send duplicate alerts. Every user belongs to one of SHARD_COUNT shards by a
+// hash of auth_user_id, and each instance checks a single shard: a fixed
+// SHARD_INDEX, or with SHARD_INDEX=auto whichever shard it can lease in
+// notifier_leases. Every instance must use the same SHARD_COUNT.
+//
+// Leader election (LEADER_ELECTION=true) is the single-shard case: replicas
+// compete for one lease, and only the holder checks subscribers.
+//
+// Seen items live in memory, so whoever claims a lease loads what was already
+// delivered from notification_history before checking (see loadSeenHistory).
+var (
+	shardCount     = 1
+	shardAuto      bool
+	leaderElection bool
+)
+
+// shardState is the shard this instance checks
+type shardState struct {
+	mu      sync.RWMutex
+	index   int       // -1 while no shard is held
+	expires time.Time // End of the lease; zero for a fixed SHARD_INDEX
+}
+
+var shard = &shardState{}
+
+func (s *shardState) set(index int, expires time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.index, s.expires = index, expires
+	shardIndex.Set(float64(index))
+	if leaderElection {
+		leader.Set(boolToFloat(index >= 0))
+	}
+}
+
+// Current returns the shard index and whether it is held right now
+func (s *shardState) Current() (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	held := s.index >= 0 && (s.expires.IsZero() || time.Now().Before(s.expires))
+	return s.index, held
+}
+
+// shardOf returns the shard a user belongs to out of count
+func shardOf(userID string, count int) int {
+	h := fnv.New32a()
+	h.Write([]byte(userID))
+	return int(h.Sum32() % uint32(count))
+}
+
+// ownsUser reports whether this instance is responsible for the user
+func ownsUser(userID string) bool {
+	if shardCount == 1 && !shardAuto {
+		return true
+	}
+	index, held := shard.Current()
+	return held && shardOf(userID, shardCount) == index
+}
+
+// shardLeaseName is the notifier_leases row for a shard. The count is part of
+// the name so instances with different SHARD_COUNTs never share a lease.
+func shardLeaseName(index int) string {
+	if shardCount == 1 {
+		return "leader"
+	}
+	return fmt.Sprintf("shard-%d-of-%d", index, shardCount)
+}
+
+// runShardLease claims a shard, keeps its lease renewed, and looks for a free
+// shard again if the lease is lost
+func runShardLease(ctx context.Context) {
+	ticker := time.NewTicker(leaseTTL / 3)
+	defer ticker.Stop()
+	for {
+		renewShardLease(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func renewShardLease(ctx context.Context) {
+	index, _ := shard.Current()
+	if index >= 0 {
+		expires, ok, err := claimLease(ctx, shardLeaseName(index), instanceID, leaseTTL)
+		switch {
+		case err != nil:
+			// Keep checking the shard until the lease runs out
+			slog.Warn("failed to renew shard lease", "shard", index, "error", err)
+			return
+		case ok:
+			shard.set(index, expires)
+			return
+		}
+		if leaderElection {
+			slog.Warn("leader lease taken by another instance, standing by")
+		} else {
+			slog.Warn("shard lease taken by another instance, no longer checking it", "shard", index)
+		}
+		shard.set(-1, time.Time{})
+	}
+
+	// Start at a random shard so instances starting together spread out
+	start := rand.IntN(shardCount)
+	for i := range shardCount {
+		candidate := (start + i) % shardCount
+		expires, ok, err := claimLease(ctx, shardLeaseName(candidate), instanceID, leaseTTL)
+		if err != nil {
+			slog.Warn("failed to claim shard lease", "shard", candidate, "error", err)
+			return
+		}
+		if ok {
+			if leaderElection {
+				slog.Info("became leader", "instance_id", instanceID)
+			} else {
+				slog.Info("claimed shard", "shard", candidate, "shard_count", shardCount, "instance_id", instanceID)
+			}
+			shard.set(candidate, expires)
+			// Check the shard's notifications as new, which first loads what
+			// the previous holder delivered. The subscriber index still holds
+			// the previous shard's users.
+			pollScheduler.Reset()
+			realtime.RequestResync()
+			select {
+			case subscribersChanged <- struct{}{}:
+			default:
+			}
+			return
+		}
+	}
+	if leaderElection {
+		slog.Debug("another instance is leader, standing by")
+		return
+	}
+	slog.Warn("all shards are leased by other instances, waiting for one to free up", "shard_count", shardCount)
+}
+
+// releaseShardOnExit gives up the shard lease when the process is told to
+// stop, so a standby takes over on its next attempt instead of waiting for
+// the lease to expire
+func releaseShardOnExit() {
+	onShutdown(func(ctx context.Context) {
+		index, held := shard.Current()
+		if !held {
+			return
+		}
+		// Stop delivering before another instance can take the shard
+		shard.set(-1, time.Time{})
+		if err := releaseLease(ctx, shardLeaseName(index), instanceID); err != nil {
+			slog.Warn("failed to release shard lease", "shard", index, "error", err)
+			return
+		}
+		slog.Info("released shard lease", "shard", index)
+	})
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
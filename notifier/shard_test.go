@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestShardOf(t *testing.T) {
+	for _, count := range []int{1, 2, 3, 8} {
+		t.Run(fmt.Sprintf("%d shards", count), func(t *testing.T) {
+			perShard := make([]int, count)
+			for i := range 1000 {
+				id := fmt.Sprintf("user-%d", i)
+				got := shardOf(id, count)
+				if got < 0 || got >= count {
+					t.Fatalf("shardOf(%q, %d) = %d, out of range", id, count, got)
+				}
+				if again := shardOf(id, count); again != got {
+					t.Fatalf("shardOf(%q, %d) = %d then %d, want it stable", id, count, got, again)
+				}
+				perShard[got]++
+			}
+			// Users spread roughly evenly over the shards
+			for index, n := range perShard {
+				if want := 1000 / count; n < want*3/4 || n > want*5/4 {
+					t.Errorf("shard %d has %d of 1000 users, want about %d", index, n, want)
+				}
+			}
+		})
+	}
+}
+
+func TestOwnsUser(t *testing.T) {
+	previousCount, previousAuto, previous := shardCount, shardAuto, shard
+	t.Cleanup(func() { shardCount, shardAuto, shard = previousCount, previousAuto, previous })
+
+	const user = "user-1"
+	shardCount = 3
+	index := shardOf(user, shardCount)
+	other := (index + 1) % shardCount
+
+	tests := []struct {
+		name    string
+		count   int
+		auto    bool
+		index   int
+		expires time.Time
+		want    bool
+	}{
+		{name: "single shard owns everyone", count: 1, index: 0, want: true},
+		{name: "fixed shard of the user", count: 3, index: index, want: true},
+		{name: "fixed shard of another user", count: 3, index: other, want: false},
+		{name: "leased shard", count: 3, index: index, expires: time.Now().Add(time.Minute), want: true},
+		{name: "expired lease", count: 3, index: index, expires: time.Now().Add(-time.Second), want: false},
+		{name: "no shard held", count: 3, index: -1, want: false},
+		{name: "leader", count: 1, auto: true, index: 0, expires: time.Now().Add(time.Minute), want: true},
+		{name: "standby", count: 1, auto: true, index: -1, want: false},
+		{name: "leader whose lease ran out", count: 1, auto: true, index: 0, expires: time.Now().Add(-time.Second), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shardCount, shardAuto = tt.count, tt.auto
+			shard = &shardState{}
+			shard.set(tt.index, tt.expires)
+			if got := ownsUser(user); got != tt.want {
+				t.Errorf("ownsUser() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// shutdownTimeout bounds how long the shutdown hooks may take in total
+const shutdownTimeout = 5 * time.Second
+
+var (
+	shutdownMu    sync.Mutex
+	shutdownHooks []func(ctx context.Context)
+)
+
+// onShutdown registers fn to run when the notifier is stopped with SIGINT or
+// SIGTERM. Hooks run in reverse order of registration and share ctx, which
+// expires after shutdownTimeout.
+func onShutdown(fn func(ctx context.Context)) {
+	shutdownMu.Lock()
+	defer shutdownMu.Unlock()
+	shutdownHooks = append(shutdownHooks, fn)
+}
+
+// exitOnSignal runs the shutdown hooks and exits on SIGINT or SIGTERM
+func exitOnSignal() {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-stop
+		slog.Info("shutting down", "signal", sig.String())
+
+		shutdownMu.Lock()
+		hooks := append([]func(context.Context){}, shutdownHooks...)
+		shutdownMu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		for i := len(hooks) - 1; i >= 0; i-- {
+			hooks[i](ctx)
+		}
+		cancel()
+		os.Exit(0)
+	}()
+}
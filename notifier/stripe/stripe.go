@@ -0,0 +1,172 @@
+// Package stripe is a small client for the parts of the Stripe API the
+// notifier reconciles subscriptions against.
+//
+//	client := stripe.NewClient(stripe.DefaultBaseURL, secretKey)
+//	subs, err := client.ListSubscriptions(ctx, "cus_123")
+package stripe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is Stripe's production API
+const DefaultBaseURL = "https://api.stripe.com"
+
+// APIVersion pins response shapes; it matches the Supabase stripe-webhook function
+const APIVersion = "2023-10-16"
+
+// Client sends requests to the Stripe API
+type Client struct {
+	BaseURL    string // e.g. https://api.stripe.com, or a stub server in tests
+	SecretKey  string
+	HTTPClient *http.Client
+
+	// Failed requests (network errors, 5xx, 429) are retried MaxRetries
+	// times, waiting RetryBackoff, then twice as long, and so on
+	MaxRetries   int
+	RetryBackoff time.Duration
+}
+
+// NewClient returns a client for the Stripe API at baseURL
+func NewClient(baseURL, secretKey string) *Client {
+	return &Client{
+		BaseURL:      strings.TrimRight(baseURL, "/"),
+		SecretKey:    secretKey,
+		HTTPClient:   &http.Client{Timeout: 30 * time.Second},
+		MaxRetries:   3,
+		RetryBackoff: time.Second,
+	}
+}
+
+// Subscription statuses that grant access
+const (
+	StatusActive   = "active"
+	StatusTrialing = "trialing"
+	StatusCanceled = "canceled"
+)
+
+// Subscription is the subset of a Stripe subscription object the notifier uses
+type Subscription struct {
+	ID                string `json:"id"`
+	Customer          string `json:"customer"`
+	Status            string `json:"status"`
+	CurrentPeriodEnd  int64  `json:"current_period_end"` // Unix seconds
+	Created           int64  `json:"created"`            // Unix seconds
+	CancelAtPeriodEnd bool   `json:"cancel_at_period_end"`
+}
+
+// Active reports whether the subscription currently grants access
+func (s Subscription) Active() bool {
+	return s.Status == StatusActive || s.Status == StatusTrialing
+}
+
+// Error is returned when Stripe responds with a non-2xx status
+type Error struct {
+	StatusCode int
+	Type       string `json:"type"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("stripe: status %d: %s", e.StatusCode, e.Message)
+}
+
+// listPageSize is the largest page Stripe returns
+const listPageSize = 100
+
+// ListSubscriptions returns every subscription of a customer, including
+// canceled ones, newest first
+func (c *Client) ListSubscriptions(ctx context.Context, customerID string) ([]Subscription, error) {
+	var subs []Subscription
+	params := url.Values{}
+	params.Set("customer", customerID)
+	params.Set("status", "all")
+	params.Set("limit", fmt.Sprint(listPageSize))
+	for {
+		var page struct {
+			Data    []Subscription `json:"data"`
+			HasMore bool           `json:"has_more"`
+		}
+		if err := c.get(ctx, "/v1/subscriptions", params, &page); err != nil {
+			return nil, err
+		}
+		subs = append(subs, page.Data...)
+		if !page.HasMore || len(page.Data) == 0 {
+			return subs, nil
+		}
+		params.Set("starting_after", page.Data[len(page.Data)-1].ID)
+	}
+}
+
+// get sends a GET request, retrying transient failures, and decodes the response into dest
+func (c *Client) get(ctx context.Context, path string, params url.Values, dest interface{}) error {
+	u := c.BaseURL + path + "?" + params.Encode()
+	backoff := c.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := c.getOnce(ctx, u, dest)
+		if err == nil || attempt >= c.MaxRetries || ctx.Err() != nil || !retryable(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) getOnce(ctx context.Context, u string, dest interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return fmt.Errorf("stripe: creating request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.SecretKey)
+	req.Header.Set("Stripe-Version", APIVersion)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var wrapper struct {
+			Error Error `json:"error"`
+		}
+		_ = json.Unmarshal(body, &wrapper)
+		apiErr := wrapper.Error
+		apiErr.StatusCode = resp.StatusCode
+		if apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(body))
+		}
+		return &apiErr
+	}
+	if err := json.Unmarshal(body, dest); err != nil {
+		return fmt.Errorf("stripe: decoding response: %w", err)
+	}
+	return nil
+}
+
+// retryable reports whether a failed request may succeed if sent again
+func retryable(err error) bool {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+	}
+	return true // Network error
+}
@@ -0,0 +1,141 @@
+// Package stripetest provides a stub Stripe API server for tests.
+//
+//	srv := stripetest.NewServer()
+//	defer srv.Close()
+//	srv.AddSubscription(stripe.Subscription{ID: "sub_1", Customer: "cus_1", Status: "active"})
+//	client := stripe.NewClient(srv.URL, "sk_test")
+//
+// It serves GET /v1/subscriptions with the customer, status (a status, or
+// "all"), limit and starting_after parameters. Like Stripe, canceled
+// subscriptions are only listed with status=all, and results are newest first.
+package stripetest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"discord-notifier/stripe"
+)
+
+// Server is a stub Stripe API backed by in-memory subscriptions
+type Server struct {
+	*httptest.Server
+
+	mu            sync.Mutex
+	subscriptions []stripe.Subscription
+	failures      []int // status codes to return for the next requests
+	requests      []*http.Request
+}
+
+// NewServer starts a stub Stripe server. Close it when done.
+func NewServer() *Server {
+	s := &Server{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// AddSubscription adds (or replaces, by ID) a subscription
+func (s *Server) AddSubscription(sub stripe.Subscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, existing := range s.subscriptions {
+		if existing.ID == sub.ID {
+			s.subscriptions[i] = sub
+			return
+		}
+	}
+	s.subscriptions = append(s.subscriptions, sub)
+}
+
+// FailNext makes the next len(statuses) requests fail with the given status codes
+func (s *Server) FailNext(statuses ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, statuses...)
+}
+
+// Requests returns the requests received so far
+func (s *Server) Requests() []*http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*http.Request(nil), s.requests...)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r)
+
+	if len(s.failures) > 0 {
+		status := s.failures[0]
+		s.failures = s.failures[1:]
+		writeError(w, status, "api_error", "injected failure")
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer sk_") {
+		writeError(w, http.StatusUnauthorized, "invalid_request_error", "Invalid API Key provided")
+		return
+	}
+	if r.Method != http.MethodGet || r.URL.Path != "/v1/subscriptions" {
+		writeError(w, http.StatusNotFound, "invalid_request_error", "Unrecognized request URL")
+		return
+	}
+
+	query := r.URL.Query()
+	status := query.Get("status")
+	matched := []stripe.Subscription{}
+	for _, sub := range s.subscriptions {
+		if customer := query.Get("customer"); customer != "" && sub.Customer != customer {
+			continue
+		}
+		switch {
+		case status == "all":
+		case status != "":
+			if sub.Status != status {
+				continue
+			}
+		case sub.Status == stripe.StatusCanceled:
+			continue
+		}
+		matched = append(matched, sub)
+	}
+	sort.SliceStable(matched, func(i, j int) bool { return matched[i].Created > matched[j].Created })
+
+	if after := query.Get("starting_after"); after != "" {
+		for i, sub := range matched {
+			if sub.ID == after {
+				matched = matched[i+1:]
+				break
+			}
+		}
+	}
+	limit := 10
+	if n, err := strconv.Atoi(query.Get("limit")); err == nil && n > 0 {
+		limit = n
+	}
+	hasMore := len(matched) > limit
+	if hasMore {
+		matched = matched[:limit]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"object":   "list",
+		"url":      "/v1/subscriptions",
+		"data":     matched,
+		"has_more": hasMore,
+	})
+}
+
+func writeError(w http.ResponseWriter, status int, errType, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{"type": errType, "message": message},
+	})
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"discord-notifier/postgrest"
+)
+
+// Subscription expiry settings. Alerts continue for the grace period after
+// notifications_subscription_expires_at (none unless configured), and the
+// user's webhook is reminded ahead of expiry, when it lapses and when alerts
+// stop.
+var (
+	subscriptionGracePeriod time.Duration
+	expiryWarningDays       = []int{1, 7} // Days before expiry to send a reminder, ascending
+	expiryWarningsDisabled  bool
+
+	// endedWarningWindow stops "alerts stopped" messages going to users whose
+	// subscription ended long before the notifier first noticed
+	endedWarningWindow = 48 * time.Hour
+)
+
+// Kinds of expiry warning, besides the "<n>d" reminders before expiry
+const (
+	expiryWarningLapsed   = "lapsed"   // Expired, alerts continue for the grace period
+	expiryWarningEnded    = "ended"    // Grace period over, alerts stopped
+	expiryWarningInactive = "inactive" // Switched off before expiring, e.g. canceled in Stripe
+)
+
+// parseExpiryWarningDays parses EXPIRY_WARNING_DAYS, e.g. "7,1"
+func parseExpiryWarningDays(value string) ([]int, error) {
+	days := []int{}
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid number of days %q", field)
+		}
+		days = append(days, n)
+	}
+	slices.Sort(days)
+	return slices.Compact(days), nil
+}
+
+// subscriptionExpiry returns when the user's subscription expires. False for
+// lifetime subscriptions and unparseable dates.
+func subscriptionExpiry(user User) (time.Time, bool) {
+	if user.SubscriptionExpiresAt == nil || *user.SubscriptionExpiresAt == "" {
+		return time.Time{}, false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, *user.SubscriptionExpiresAt)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return expiresAt, true
+}
+
+// expiryWarningFor returns the warning due for a subscription expiring at
+// expiresAt, or "" if none is. Only the latest applicable warning is due, so a
+// user first seen a day before expiry isn't also sent the week-ahead reminder.
+func expiryWarningFor(expiresAt, now time.Time) string {
+	graceEnd := expiresAt.Add(subscriptionGracePeriod)
+	switch {
+	case !now.Before(graceEnd):
+		if now.Sub(graceEnd) < endedWarningWindow {
+			return expiryWarningEnded
+		}
+		return ""
+	case !now.Before(expiresAt):
+		return expiryWarningLapsed
+	}
+	for _, days := range expiryWarningDays {
+		if expiresAt.Sub(now) <= time.Duration(days)*24*time.Hour {
+			return strconv.Itoa(days) + "d"
+		}
+	}
+	return ""
+}
+
+// expiryWarningRow is a row of the subscription_warnings table. The expiry is
+// part of the key, so renewing starts a fresh set of warnings.
+type expiryWarningRow struct {
+	AuthUserID string `json:"auth_user_id"`
+	ExpiresAt  string `json:"expires_at"`
+	Kind       string `json:"kind"`
+}
+
+// expiryWarningLog tracks sent warnings. subscription_warnings is the record
+// shared by every instance and across restarts; sent caches it so due
+// warnings aren't re-checked against Supabase on every sync.
+type expiryWarningLog struct {
+	mu      sync.Mutex
+	sent    map[expiryWarningRow]bool
+	noTable atomic.Bool // subscription_warnings is missing; track in memory only
+}
+
+var expiryWarnings = &expiryWarningLog{sent: make(map[expiryWarningRow]bool)}
+
+// claim marks a warning as sent before it is sent, so only one instance sends
+// it. Returns false if it was already sent.
+func (l *expiryWarningLog) claim(ctx context.Context, row expiryWarningRow) (bool, error) {
+	l.mu.Lock()
+	if l.sent[row] {
+		l.mu.Unlock()
+		return false, nil
+	}
+	l.sent[row] = true
+	l.mu.Unlock()
+
+	if l.noTable.Load() {
+		return true, nil
+	}
+	err := db.From("subscription_warnings").Insert(ctx, row)
+	var apiErr *postgrest.Error
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict:
+		return false, nil
+	case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound:
+		if !l.noTable.Swap(true) {
+			loggerFrom(ctx).Warn("subscription_warnings table missing, warnings may repeat after restarts; run the Supabase migrations", "error", err)
+		}
+		return true, nil
+	}
+	l.forget(ctx, row)
+	return false, err
+}
+
+// forget un-claims a warning that couldn't be sent so it is tried again
+func (l *expiryWarningLog) forget(ctx context.Context, row expiryWarningRow) {
+	l.mu.Lock()
+	delete(l.sent, row)
+	l.mu.Unlock()
+
+	if l.noTable.Load() {
+		return
+	}
+	err := db.From("subscription_warnings").
+		Eq("auth_user_id", row.AuthUserID).
+		Eq("expires_at", row.ExpiresAt).
+		Eq("kind", row.Kind).
+		Delete(ctx)
+	if err != nil {
+		loggerFrom(ctx).Warn("failed to clear unsent subscription warning", "user_id", row.AuthUserID, "kind", row.Kind, "error", err)
+	}
+}
+
+// sendExpiryWarnings sends each user whatever expiry warning is due and
+// hasn't been sent yet. users should include expired subscribers; inactive
+// users, from fetchInactiveSubscribers, are told their alerts stopped.
+func sendExpiryWarnings(ctx context.Context, users []User) {
+	if expiryWarningsDisabled {
+		return
+	}
+	now := time.Now()
+	for _, user := range users {
+		expiresAt, ok := subscriptionExpiry(user)
+		if !ok {
+			continue
+		}
+		kind := expiryWarningInactive
+		if user.SubscriptionActive {
+			kind = expiryWarningFor(expiresAt, now)
+		}
+		if kind == "" {
+			continue
+		}
+		webhookURL := expiryWarningWebhook(user)
+		if webhookURL == "" {
+			continue
+		}
+
+		logger := loggerFrom(ctx).With("user_id", user.AuthUserID, "kind", kind, "expires_at", expiresAt)
+		row := expiryWarningRow{AuthUserID: user.AuthUserID, ExpiresAt: expiresAt.UTC().Format(time.RFC3339), Kind: kind}
+		claimed, err := expiryWarnings.claim(ctx, row)
+		if err != nil {
+			logger.Warn("failed to record subscription warning", "error", err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		payload := DiscordWebhookPayload{Embeds: []DiscordEmbed{expiryWarningEmbed(kind, expiresAt)}}
+		jsonData, err := json.Marshal(payload)
+		if err == nil {
+			err = postDiscordWebhook(ctx, webhookURL, jsonData, len(payload.Embeds))
+		}
+		if err != nil {
+			logger.Warn("failed to send subscription warning", "error", err)
+			subscriptionWarnings.WithLabelValues(kind, "error").Inc()
+			expiryWarnings.forget(ctx, row)
+			continue
+		}
+		logger.Info("sent subscription warning")
+		subscriptionWarnings.WithLabelValues(kind, "success").Inc()
+	}
+}
+
+// expiryWarningWebhook picks where to warn a user: their global webhook, or
+// else the first webhook of their saved searches
+func expiryWarningWebhook(user User) string {
+	if isDiscordWebhookURL(user.DiscordWebhookURL) {
+		return user.DiscordWebhookURL
+	}
+	for _, notif := range user.Notifications {
+		for _, webhook := range notif.Webhooks {
+			if webhook = strings.TrimSpace(webhook); isDiscordWebhookURL(webhook) {
+				return webhook
+			}
+		}
+	}
+	return ""
+}
+
+// expiryWarningEmbed renders a warning. Dates use Discord timestamps, which
+// show in the reader's own time zone.
+func expiryWarningEmbed(kind string, expiresAt time.Time) DiscordEmbed {
+	expires := fmt.Sprintf("<t:%d:D>", expiresAt.Unix())
+	graceEnd := fmt.Sprintf("<t:%d:D>", expiresAt.Add(subscriptionGracePeriod).Unix())
+
+	embed := DiscordEmbed{
+		Color:     15105570, // Orange
+		Timestamp: time.Now().Format(time.RFC3339),
+		Footer:    map[string]interface{}{"text": "MMCS • Notifications subscription"},
+	}
+	switch kind {
+	case expiryWarningLapsed:
+		embed.Title = "Your MMCS notifications subscription has expired"
+		embed.Description = fmt.Sprintf("It expired on %s. Alerts for your saved searches continue until %s; renew before then to keep them.", expires, graceEnd)
+	case expiryWarningInactive:
+		embed.Title = "Your MMCS notifications have stopped"
+		embed.Description = "Your subscription is no longer active, so alerts for your saved searches have stopped. Renew to turn them back on; your saved searches are kept."
+		embed.Color = 15158332 // Red
+	case expiryWarningEnded:
+		embed.Title = "Your MMCS notifications have stopped"
+		embed.Description = fmt.Sprintf("Your subscription expired on %s, so alerts for your saved searches have stopped. Renew to turn them back on; your saved searches are kept.", expires)
+		embed.Color = 15158332 // Red
+	default:
+		embed.Title = "Your MMCS notifications subscription expires soon"
+		embed.Description = fmt.Sprintf("It expires on %s (<t:%d:R>). Renew to keep receiving alerts for your saved searches.", expires, expiresAt.Unix())
+	}
+	return embed
+}
+
+// deactivatedLog collects subscribers dropped from the index because their
+// subscription was switched off, until the next sync warns them
+type deactivatedLog struct {
+	mu    sync.Mutex
+	users map[string]User
+}
+
+var deactivated = &deactivatedLog{users: make(map[string]User)}
+
+// Add remembers users as they were while active
+func (l *deactivatedLog) Add(users ...User) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, user := range users {
+		l.users[user.AuthUserID] = user
+	}
+}
+
+// Take returns and forgets the collected users
+func (l *deactivatedLog) Take() []User {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	users := make([]User, 0, len(l.users))
+	for _, user := range l.users {
+		users = append(users, user)
+	}
+	clear(l.users)
+	return users
+}
+
+// droppedSubscribers returns the users in previous that aren't in current
+func droppedSubscribers(previous, current []User) []User {
+	kept := make(map[string]bool, len(current))
+	for _, user := range current {
+		kept[user.AuthUserID] = true
+	}
+	var dropped []User
+	for _, user := range previous {
+		if !kept[user.AuthUserID] {
+			dropped = append(dropped, user)
+		}
+	}
+	return dropped
+}
+
+// inactiveLookupBatch is how many dropped users are looked up per query,
+// keeping the filter within URL length limits
+const inactiveLookupBatch = 50
+
+// fetchInactiveSubscribers returns the users in dropped that are now
+// inactive and may be due an "alerts stopped" warning. Stripe clears the
+// expiry of a canceled subscription; such users keep the expiry they had while
+// active, or else the current hour, to key their warning.
+func fetchInactiveSubscribers(ctx context.Context, dropped []User) ([]User, error) {
+	var users []User
+	previous := make(map[string]User, len(dropped))
+	for _, user := range dropped {
+		previous[user.AuthUserID] = user
+	}
+	for start := 0; start < len(dropped); start += inactiveLookupBatch {
+		var ids []string
+		for _, user := range dropped[start:min(start+inactiveLookupBatch, len(dropped))] {
+			ids = append(ids, user.AuthUserID)
+		}
+		var rows []User
+		_, err := db.From("unlocked_users").
+			Select(subscriberColumns...).
+			Eq("notifications_subscription_active", "false").
+			In("auth_user_id", ids...).
+			Execute(ctx, &rows)
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range rows {
+			if _, ok := subscriptionExpiry(user); !ok {
+				user.SubscriptionExpiresAt = previous[user.AuthUserID].SubscriptionExpiresAt
+				if _, ok := subscriptionExpiry(user); !ok {
+					keyed := time.Now().UTC().Truncate(time.Hour).Format(time.RFC3339)
+					user.SubscriptionExpiresAt = &keyed
+				}
+			}
+			users = append(users, user)
+		}
+	}
+
+	inactive := make([]User, 0, len(users))
+	for _, user := range users {
+		if !ownsUser(user.AuthUserID) {
+			continue
+		}
+		if notificationsSource == notificationsSourceTable {
+			notifications, err := fetchUserNotifications(ctx, user.AuthUserID)
+			if err != nil {
+				return nil, err
+			}
+			user.Notifications = notifications
+		} else {
+			user.Notifications = parseNotifications(user.AuthUserID, user.DiscordNotifications)
+		}
+		user.DiscordWebhookURL = cleanWebhookURL(strings.TrimSpace(user.DiscordWebhookURL))
+		if expiryWarningWebhook(user) != "" {
+			inactive = append(inactive, user)
+		}
+	}
+	return inactive, nil
+}
+
+// warnInactiveSubscribers tells users dropped from the subscribers since the
+// last sync that their alerts stopped, if they were switched off. They are
+// kept for the next sync if the lookup fails.
+func warnInactiveSubscribers(ctx context.Context) {
+	dropped := deactivated.Take()
+	if expiryWarningsDisabled || len(dropped) == 0 {
+		return
+	}
+	users, err := fetchInactiveSubscribers(ctx, dropped)
+	if err != nil {
+		loggerFrom(ctx).Warn("failed to fetch inactive subscribers to warn", "error", err)
+		deactivated.Add(dropped...)
+		return
+	}
+	sendExpiryWarnings(ctx, users)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"discord-notifier/postgrest/postgresttest"
+)
+
+func TestExpiryWarningFor(t *testing.T) {
+	previousGrace, previousDays := subscriptionGracePeriod, expiryWarningDays
+	t.Cleanup(func() { subscriptionGracePeriod, expiryWarningDays = previousGrace, previousDays })
+	expiryWarningDays = []int{1, 7}
+
+	expiresAt := newFakeClock().Now()
+	day := 24 * time.Hour
+
+	tests := []struct {
+		name  string
+		grace time.Duration
+		now   time.Time
+		want  string
+	}{
+		{name: "well before expiry", now: expiresAt.Add(-8 * day), want: ""},
+		{name: "within a week", now: expiresAt.Add(-7 * day), want: "7d"},
+		{name: "within a week but over a day", now: expiresAt.Add(-day - time.Minute), want: "7d"},
+		{name: "within a day takes the latest reminder", now: expiresAt.Add(-day), want: "1d"},
+		{name: "moments before expiry", now: expiresAt.Add(-time.Second), want: "1d"},
+		{name: "expired without grace has ended", now: expiresAt, want: expiryWarningEnded},
+		{name: "expired in grace has lapsed", grace: 3 * day, now: expiresAt, want: expiryWarningLapsed},
+		{name: "end of grace", grace: 3 * day, now: expiresAt.Add(3 * day), want: expiryWarningEnded},
+		{name: "ended within the window", now: expiresAt.Add(47 * time.Hour), want: expiryWarningEnded},
+		{name: "ended long before it was noticed", now: expiresAt.Add(48 * time.Hour), want: ""},
+		{name: "grace moves the window", grace: 3 * day, now: expiresAt.Add(4 * day), want: expiryWarningEnded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subscriptionGracePeriod = tt.grace
+			if got := expiryWarningFor(expiresAt, tt.now); got != tt.want {
+				t.Errorf("expiryWarningFor() at %v = %q, want %q", tt.now.Sub(expiresAt), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseExpiryWarningDays(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []int
+		wantErr bool
+	}{
+		{value: "7,1", want: []int{1, 7}},
+		{value: " 3 , 3,14", want: []int{3, 14}},
+		{value: "", want: []int{}},
+		{value: "0", wantErr: true},
+		{value: "week", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseExpiryWarningDays(tt.value)
+		if (err != nil) != tt.wantErr || !slices.Equal(got, tt.want) {
+			t.Errorf("parseExpiryWarningDays(%q) = %v, %v, want %v (error %v)", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func useWarningsTable(t *testing.T) *postgresttest.Server {
+	t.Helper()
+	srv := useTestDB(t)
+	srv.SetRows("subscription_warnings", nil)
+	srv.SetPrimaryKey("subscription_warnings", "auth_user_id", "expires_at", "kind")
+	return srv
+}
+
+func TestExpiryWarningLogClaim(t *testing.T) {
+	srv := useWarningsTable(t)
+	ctx := context.Background()
+	row := expiryWarningRow{AuthUserID: "u1", ExpiresAt: "2026-10-25T00:00:00Z", Kind: "7d"}
+
+	log := &expiryWarningLog{sent: make(map[expiryWarningRow]bool)}
+	if claimed, err := log.claim(ctx, row); err != nil || !claimed {
+		t.Fatalf("first claim = %v, %v, want claimed", claimed, err)
+	}
+	if claimed, err := log.claim(ctx, row); err != nil || claimed {
+		t.Errorf("second claim = %v, %v, want already sent", claimed, err)
+	}
+
+	// Another instance's insert fails with 409, which means already sent
+	other := &expiryWarningLog{sent: make(map[expiryWarningRow]bool)}
+	if claimed, err := other.claim(ctx, row); err != nil || claimed {
+		t.Errorf("claim by another instance = %v, %v, want already sent", claimed, err)
+	}
+
+	// Renewing moves the expiry, which is a fresh warning
+	renewed := row
+	renewed.ExpiresAt = "2026-11-25T00:00:00Z"
+	if claimed, err := other.claim(ctx, renewed); err != nil || !claimed {
+		t.Errorf("claim after renewal = %v, %v, want claimed", claimed, err)
+	}
+	if n := len(srv.Rows("subscription_warnings")); n != 2 {
+		t.Errorf("%d warning rows, want 2", n)
+	}
+}
+
+func TestExpiryWarningLogFailedClaimIsForgotten(t *testing.T) {
+	srv := useWarningsTable(t)
+	ctx := context.Background()
+	row := expiryWarningRow{AuthUserID: "u1", ExpiresAt: "2026-10-25T00:00:00Z", Kind: "1d"}
+
+	log := &expiryWarningLog{sent: make(map[expiryWarningRow]bool)}
+	srv.FailNext(http.StatusBadRequest)
+	if claimed, err := log.claim(ctx, row); err == nil || claimed {
+		t.Fatalf("claim with a failing insert = %v, %v, want an error", claimed, err)
+	}
+	if claimed, err := log.claim(ctx, row); err != nil || !claimed {
+		t.Errorf("retried claim = %v, %v, want claimed", claimed, err)
+	}
+}
+
+func TestExpiryWarningLogWithoutTable(t *testing.T) {
+	useTestDB(t)
+	ctx := context.Background()
+	row := expiryWarningRow{AuthUserID: "u1", ExpiresAt: "2026-10-25T00:00:00Z", Kind: expiryWarningLapsed}
+
+	log := &expiryWarningLog{sent: make(map[expiryWarningRow]bool)}
+	if claimed, err := log.claim(ctx, row); err != nil || !claimed {
+		t.Fatalf("claim without the table = %v, %v, want claimed", claimed, err)
+	}
+	if !log.noTable.Load() {
+		t.Error("noTable not set after a 404")
+	}
+	if claimed, _ := log.claim(ctx, row); claimed {
+		t.Error("second claim without the table succeeded, want it tracked in memory")
+	}
+}
+
+func TestWarnInactiveSubscribersSkipsLookupWithoutDropped(t *testing.T) {
+	srv := useTestDB(t)
+	srv.SetRows("unlocked_users", nil)
+	deactivated.Take()
+
+	warnInactiveSubscribers(context.Background())
+	if n := len(srv.Requests()); n != 0 {
+		t.Errorf("%d requests with nobody deactivated, want none", n)
+	}
+}
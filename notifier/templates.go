@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// MessageTemplate customizes how a notification's alerts are worded. Each
+// part is a Go text/template; empty parts keep the default format.
+type MessageTemplate struct {
+	Style       string          `json:"style,omitempty"`       // "embed" (default) or "compact", which sends text only
+	Content     string          `json:"content,omitempty"`     // Message text, rendered once per message from messageTemplateData
+	Title       string          `json:"title,omitempty"`       // Embed title, rendered once per item from itemTemplateData
+	Description string          `json:"description,omitempty"` // Embed description, likewise
+	Fields      []TemplateField `json:"fields,omitempty"`      // Replace the default fields; fields rendering empty are left out
+}
+
+// TemplateField is an embed field whose name and value are templates
+type TemplateField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+// Template styles
+const (
+	templateStyleEmbed   = "embed"
+	templateStyleCompact = "compact"
+)
+
+// Limits on templates, keeping them within what Discord accepts and what a
+// cycle can afford to render
+const (
+	maxTemplateSource = 2000 // Characters per template
+	maxTemplateFields = 10
+	maxTemplateOutput = 4096 // Bytes a template may render before it fails
+	maxFormatWidth    = 256  // Largest width or precision printf accepts
+)
+
+// messageTemplateData is what a content template renders from
+type messageTemplateData struct {
+	SearchTerm string
+	Total      int                // New items in the alert
+	Items      []itemTemplateData // Items in this message
+	First      int                // Position of the message's first item, from 1
+	Last       int                // Position of its last item
+}
+
+// itemTemplateData is what title, description and field templates render
+// from, and each of messageTemplateData.Items
+type itemTemplateData struct {
+	SearchTerm    string
+	Title         string // English if translated
+	OriginalTitle string
+	URL           string
+	ProxyURL      string
+	Image         string
+	Market        string
+	Price         string // Formatted in the user's currency, as in the default format
+	PriceYen      int
+	PriceUSD      int
+	Labels        []string // Humanized, e.g. "Free shipping"
+}
+
+func newItemTemplateData(notification Notification, item alertItem, currency string) itemTemplateData {
+	labels := []string{}
+	if formatted := formatLabels(item.Labels); formatted != "" {
+		labels = strings.Split(formatted, "\n")
+	}
+	return itemTemplateData{
+		SearchTerm:    notification.SearchTerm,
+		Title:         item.Title,
+		OriginalTitle: item.OriginalTitle,
+		URL:           item.URL,
+		ProxyURL:      item.ProxyURL,
+		Image:         item.Image,
+		Market:        getMarketNameFromShop(item.Shop),
+		Price:         formatPrice(item, currency),
+		PriceYen:      item.PriceYen,
+		PriceUSD:      item.PriceUSD,
+		Labels:        labels,
+	}
+}
+
+// templateFuncs are the functions templates may call, besides text/template's
+// builtins. printf is replaced with a version that rejects huge widths.
+var templateFuncs = template.FuncMap{
+	"upper":    strings.ToUpper,
+	"lower":    strings.ToLower,
+	"trim":     strings.TrimSpace,
+	"contains": func(substr, s string) bool { return strings.Contains(strings.ToLower(s), strings.ToLower(substr)) },
+	"replace":  func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"join":     func(sep string, items []string) string { return strings.Join(items, sep) },
+	"truncate": func(n int, s string) string {
+		if n < 4 {
+			n = 4
+		}
+		return truncateText(s, n)
+	},
+	"default": func(fallback, s string) string {
+		if strings.TrimSpace(s) == "" {
+			return fallback
+		}
+		return s
+	},
+	"printf": safePrintf,
+	"call": func(...interface{}) (string, error) {
+		return "", errors.New("call is not allowed")
+	},
+}
+
+// formatWidths finds the width and precision of printf verbs
+var formatWidths = regexp.MustCompile(`%[-+# 0]*(\*|\d*)(?:\.(\*|\d*))?`)
+
+func safePrintf(format string, args ...interface{}) (string, error) {
+	for _, match := range formatWidths.FindAllStringSubmatch(format, -1) {
+		for _, n := range match[1:] {
+			if n == "*" {
+				return "", errors.New("printf: * widths are not allowed")
+			}
+			if width, _ := strconv.Atoi(n); width > maxFormatWidth {
+				return "", fmt.Errorf("printf: widths over %d are not allowed", maxFormatWidth)
+			}
+		}
+	}
+	return fmt.Sprintf(format, args...), nil
+}
+
+// compiledTemplate is a parsed MessageTemplate; nil parts use the default
+type compiledTemplate struct {
+	compact     bool
+	content     *template.Template
+	title       *template.Template
+	description *template.Template
+	fields      []compiledField
+}
+
+type compiledField struct {
+	name, value *template.Template
+	inline      bool
+}
+
+// compileTemplate parses and checks a notification's template. A nil
+// template compiles to nil, the default format.
+func compileTemplate(t *MessageTemplate) (*compiledTemplate, error) {
+	if t == nil {
+		return nil, nil
+	}
+	compiled := &compiledTemplate{}
+	switch t.Style {
+	case "", templateStyleEmbed:
+	case templateStyleCompact:
+		compiled.compact = true
+	default:
+		return nil, fmt.Errorf("unknown style %q, expected embed or compact", t.Style)
+	}
+	if len(t.Fields) > maxTemplateFields {
+		return nil, fmt.Errorf("%d fields, at most %d are allowed", len(t.Fields), maxTemplateFields)
+	}
+
+	var err error
+	if compiled.content, err = parseTemplatePart("content", t.Content); err != nil {
+		return nil, err
+	}
+	if compiled.title, err = parseTemplatePart("title", t.Title); err != nil {
+		return nil, err
+	}
+	if compiled.description, err = parseTemplatePart("description", t.Description); err != nil {
+		return nil, err
+	}
+	for i, field := range t.Fields {
+		name, err := parseTemplatePart(fmt.Sprintf("fields[%d].name", i), field.Name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := parseTemplatePart(fmt.Sprintf("fields[%d].value", i), field.Value)
+		if err != nil {
+			return nil, err
+		}
+		if name == nil || value == nil {
+			return nil, fmt.Errorf("fields[%d]: name and value are required", i)
+		}
+		compiled.fields = append(compiled.fields, compiledField{name: name, value: value, inline: field.Inline})
+	}
+	return compiled, nil
+}
+
+// parseTemplatePart parses one part of a template, or returns nil if it is empty
+func parseTemplatePart(name, source string) (*template.Template, error) {
+	if strings.TrimSpace(source) == "" {
+		return nil, nil
+	}
+	if len([]rune(source)) > maxTemplateSource {
+		return nil, fmt.Errorf("%s: longer than %d characters", name, maxTemplateSource)
+	}
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	if len(tmpl.Templates()) > 1 {
+		return nil, fmt.Errorf("%s: define and block are not allowed", name)
+	}
+	if err := checkTemplateNode(name, tmpl.Tree.Root); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// checkTemplateNode rejects constructs that could run unbounded: including
+// other templates, and ranging over anything but the Items and Labels lists
+// (text/template can range over integers)
+func checkTemplateNode(name string, node parse.Node) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := checkTemplateNode(name, child); err != nil {
+				return err
+			}
+		}
+	case *parse.TemplateNode:
+		return fmt.Errorf("%s: template is not allowed", name)
+	case *parse.RangeNode:
+		if !rangesOverList(n.Pipe) {
+			return fmt.Errorf("%s: range is only allowed over .Items and .Labels", name)
+		}
+		if err := checkTemplateNode(name, n.List); err != nil {
+			return err
+		}
+		return checkTemplateNode(name, n.ElseList)
+	case *parse.IfNode:
+		if err := checkTemplateNode(name, n.List); err != nil {
+			return err
+		}
+		return checkTemplateNode(name, n.ElseList)
+	case *parse.WithNode:
+		if err := checkTemplateNode(name, n.List); err != nil {
+			return err
+		}
+		return checkTemplateNode(name, n.ElseList)
+	}
+	return nil
+}
+
+func rangesOverList(pipe *parse.PipeNode) bool {
+	if pipe == nil || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+	field, ok := pipe.Cmds[0].Args[0].(*parse.FieldNode)
+	if !ok || len(field.Ident) == 0 {
+		return false
+	}
+	last := field.Ident[len(field.Ident)-1]
+	return last == "Items" || last == "Labels"
+}
+
+// validateTemplate checks that a template compiles and renders a sample alert
+func validateTemplate(t *MessageTemplate) error {
+	compiled, err := compileTemplate(t)
+	if err != nil || compiled == nil {
+		return err
+	}
+	item := itemTemplateData{
+		SearchTerm:    "sample",
+		Title:         "Sample listing",
+		OriginalTitle: "サンプル",
+		URL:           "https://jp.mercari.com/",
+		Market:        "Mercari Japan",
+		Price:         "¥5,000 ($33)",
+		PriceYen:      5000,
+		PriceUSD:      33,
+		Labels:        []string{"Used"},
+	}
+	data := messageTemplateData{SearchTerm: "sample", Total: 1, Items: []itemTemplateData{item}, First: 1, Last: 1}
+	if _, err := renderTemplate(compiled.content, data); err != nil {
+		return err
+	}
+	for _, part := range []*template.Template{compiled.title, compiled.description} {
+		if _, err := renderTemplate(part, item); err != nil {
+			return err
+		}
+	}
+	for _, field := range compiled.fields {
+		if _, err := renderTemplate(field.name, item); err != nil {
+			return err
+		}
+		if _, err := renderTemplate(field.value, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderTemplate executes a template part, trimming the result. A nil part
+// renders nothing.
+func renderTemplate(tmpl *template.Template, data interface{}) (string, error) {
+	if tmpl == nil {
+		return "", nil
+	}
+	out := &cappedBuffer{max: maxTemplateOutput}
+	if err := tmpl.Execute(out, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// cappedBuffer fails writes beyond max bytes, which stops template execution
+type cappedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.max {
+		return 0, fmt.Errorf("output longer than %d bytes", b.max)
+	}
+	return b.Buffer.Write(p)
+}
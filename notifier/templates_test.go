@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateTemplateRejectsUnsafeTemplates(t *testing.T) {
+	tooManyFields := make([]TemplateField, maxTemplateFields+1)
+	for i := range tooManyFields {
+		tooManyFields[i] = TemplateField{Name: "Name", Value: "{{.Price}}"}
+	}
+
+	tests := []struct {
+		name    string
+		tmpl    MessageTemplate
+		wantErr string
+	}{
+		{name: "define", tmpl: MessageTemplate{Title: `{{define "x"}}loop{{end}}{{.Title}}`}, wantErr: "define and block are not allowed"},
+		{name: "block", tmpl: MessageTemplate{Title: `{{block "x" .}}{{.Title}}{{end}}`}, wantErr: "define and block are not allowed"},
+		{name: "template", tmpl: MessageTemplate{Description: `{{template "title"}}`}, wantErr: "template is not allowed"},
+		{name: "range over an integer", tmpl: MessageTemplate{Description: `{{range 1000000000}}x{{end}}`}, wantErr: "range is only allowed over .Items and .Labels"},
+		{name: "range over a number field", tmpl: MessageTemplate{Content: `{{range .Total}}x{{end}}`}, wantErr: "range is only allowed"},
+		{name: "nested range over an integer", tmpl: MessageTemplate{Content: `{{range .Items}}{{if .Title}}{{range 10}}x{{end}}{{end}}{{end}}`}, wantErr: "range is only allowed"},
+		{name: "printf star width", tmpl: MessageTemplate{Title: `{{printf "%*d" 100000000 1}}`}, wantErr: "* widths are not allowed"},
+		{name: "printf star precision", tmpl: MessageTemplate{Title: `{{printf "%.*f" 100000000 1.0}}`}, wantErr: "* widths are not allowed"},
+		{name: "printf wide width", tmpl: MessageTemplate{Title: `{{printf "%257s" .Title}}`}, wantErr: "widths over 256 are not allowed"},
+		{name: "printf wide precision", tmpl: MessageTemplate{Title: `{{printf "%.1000000f" 1.0}}`}, wantErr: "widths over 256 are not allowed"},
+		{name: "output over the limit", tmpl: MessageTemplate{Description: strings.Repeat(`{{printf "%256s" .Title}}`, 17)}, wantErr: "output longer than 4096 bytes"},
+		{name: "call", tmpl: MessageTemplate{Title: `{{call .Title}}`}, wantErr: "call is not allowed"},
+		{name: "too many fields", tmpl: MessageTemplate{Fields: tooManyFields}, wantErr: fmt.Sprintf("at most %d are allowed", maxTemplateFields)},
+		{name: "field without a value", tmpl: MessageTemplate{Fields: []TemplateField{{Name: "Price"}}}, wantErr: "name and value are required"},
+		{name: "unknown style", tmpl: MessageTemplate{Style: "fancy"}, wantErr: "unknown style"},
+		{name: "missing key", tmpl: MessageTemplate{Title: `{{.Seller}}`}, wantErr: "Seller"},
+		{name: "source too long", tmpl: MessageTemplate{Content: strings.Repeat("x", maxTemplateSource+1)}, wantErr: "longer than 2000 characters"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTemplate(&tt.tmpl)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateTemplate() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateTemplateAcceptsSafeTemplates(t *testing.T) {
+	for _, tmpl := range []MessageTemplate{
+		{},
+		{Style: templateStyleCompact, Content: `{{range .Items}}{{.Title}} {{.Price}}{{"\n"}}{{end}}`},
+		{Title: `{{printf "%-256s" .Title | trim}}`, Description: `{{range .Labels}}{{.}} {{end}}`},
+		{Fields: []TemplateField{{Name: "Market", Value: "{{.Market}}"}, {Name: "Price", Value: "{{.Price}}"}}},
+	} {
+		if err := validateTemplate(&tmpl); err != nil {
+			t.Errorf("validateTemplate(%+v) = %v, want nil", tmpl, err)
+		}
+	}
+}
+
+func TestBuildDiscordPayloadsWithTemplate(t *testing.T) {
+	notification := Notification{
+		ID:         "n1",
+		SearchTerm: "miku",
+		Template: &MessageTemplate{
+			Content:     `{{.Total}} new for {{upper .SearchTerm}}`,
+			Title:       `[{{.Market}}] {{truncate 20 .Title}}`,
+			Description: `{{.OriginalTitle}}`,
+			Fields: []TemplateField{
+				{Name: "Price", Value: "{{.Price}}", Inline: true},
+				{Name: "Labels", Value: `{{join ", " .Labels}}`},
+				{Name: "Empty", Value: `{{if .Labels}}{{end}}`}, // Rendering empty, so left out
+			},
+		},
+	}
+	items := []alertItem{{
+		Shop:          SendicoMercari,
+		Code:          "m1",
+		Title:         "Hatsune Miku figure",
+		OriginalTitle: "初音ミク フィギュア",
+		URL:           "https://jp.mercari.com/item/m1",
+		PriceYen:      5000,
+		Labels:        []string{"free_shipping"},
+	}}
+
+	payloads := buildDiscordPayloads(notification, items, "JPY")
+	if len(payloads) != 1 || len(payloads[0].Embeds) != 1 {
+		t.Fatalf("payloads = %+v, want one message with one embed", payloads)
+	}
+	if got, want := payloads[0].Content, "1 new for MIKU"; got != want {
+		t.Errorf("content = %q, want %q", got, want)
+	}
+
+	embed := payloads[0].Embeds[0]
+	if got, want := embed.Title, "[Mercari Japan] Hatsune Miku figure"; got != want {
+		t.Errorf("title = %q, want %q", got, want)
+	}
+	if got, want := embed.Description, "初音ミク フィギュア"; got != want {
+		t.Errorf("description = %q, want %q", got, want)
+	}
+	wantFields := []DiscordEmbedField{
+		{Name: "Price", Value: "¥5,000", Inline: true},
+		{Name: "Labels", Value: formatLabels(items[0].Labels)},
+	}
+	if !reflect.DeepEqual(embed.Fields, wantFields) {
+		t.Errorf("fields = %+v, want %+v", embed.Fields, wantFields)
+	}
+	// Parts the template doesn't set keep the default format
+	if embed.URL != items[0].URL {
+		t.Errorf("URL = %q, want %q", embed.URL, items[0].URL)
+	}
+}
+
+func TestBuildDiscordPayloadsFallsBackOnTemplateError(t *testing.T) {
+	notification := Notification{ID: "n1", SearchTerm: "miku", Template: &MessageTemplate{Title: `{{call .Title}}`}}
+	items := []alertItem{{Shop: SendicoMercari, Code: "m1", Title: "Hatsune Miku figure", PriceYen: 5000}}
+
+	payloads := buildDiscordPayloads(notification, items, "JPY")
+	if got, want := payloads[0].Embeds[0].Title, itemEmbed(notification, items[0], "JPY").Title; got != want {
+		t.Errorf("title = %q, want the default %q", got, want)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tierLimits caps what a subscription tier (unlocked_users.notifications_tier)
+// may use. Zero means no limit.
+type tierLimits struct {
+	MaxNotifications int           // Saved searches checked, in the order the user saved them
+	MaxWebhooks      int           // Webhooks alerted per saved search
+	MaxMarkets       int           // Markets searched per saved search; none selected counts as every Sendico market
+	MinPollInterval  time.Duration // Fastest a hot saved search is re-checked; zero means defaultMinPollInterval
+}
+
+// tiers are set by TIER_LIMITS. The default tier ("") has no limits unless
+// it is configured, so subscribers keep everything they saved.
+var tiers = map[string]tierLimits{"": {}}
+
+// parseTierLimits parses TIER_LIMITS: tiers separated by semicolons, each a
+// name ("default" for users without a tier) and its limits, e.g.
+//
+//	default:notifications=10,webhooks=2,markets=3,poll=1m;pro:notifications=50,poll=30s
+func parseTierLimits(value string) (map[string]tierLimits, error) {
+	parsed := map[string]tierLimits{"": {}}
+	for _, spec := range strings.Split(value, ";") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		name, fields, ok := strings.Cut(spec, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid tier %q, expected name:limit=value,...", spec)
+		}
+		if name == "default" {
+			name = ""
+		}
+
+		var limits tierLimits
+		for _, field := range strings.Split(fields, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				continue
+			}
+			key, raw, _ := strings.Cut(field, "=")
+			raw = strings.TrimSpace(raw)
+			var err error
+			switch strings.TrimSpace(key) {
+			case "notifications":
+				limits.MaxNotifications, err = parseLimit(raw)
+			case "webhooks":
+				limits.MaxWebhooks, err = parseLimit(raw)
+			case "markets":
+				limits.MaxMarkets, err = parseLimit(raw)
+			case "poll":
+				limits.MinPollInterval, err = time.ParseDuration(raw)
+				if err == nil && limits.MinPollInterval < 0 {
+					err = fmt.Errorf("negative interval")
+				}
+			default:
+				return nil, fmt.Errorf("unknown limit %q, expected notifications, webhooks, markets or poll", key)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid limit %q: %w", field, err)
+			}
+		}
+		parsed[name] = limits
+	}
+	return parsed, nil
+}
+
+func parseLimit(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err == nil && n < 0 {
+		return 0, fmt.Errorf("negative limit")
+	}
+	return n, err
+}
+
+// limitsFor returns the limits of a user's tier. Unknown tiers get the default.
+func limitsFor(user User) tierLimits {
+	if limits, ok := tiers[user.Tier]; ok {
+		return limits
+	}
+	return tiers[""]
+}
+
+// tierName is how a tier is named in messages to the user
+func tierName(user User) string {
+	if _, ok := tiers[user.Tier]; !ok || user.Tier == "" {
+		return "default"
+	}
+	return user.Tier
+}
+
+// applyTierLimits drops whatever the user's saved searches use beyond their
+// tier and records what was dropped in user.LimitNotices. Saved searches over
+// the limit are reported in notification_status. Notices from an earlier call
+// are kept when nothing more is dropped, so applying it twice is harmless.
+func applyTierLimits(user *User) {
+	limits := limitsFor(*user)
+	var notices []string
+
+	if limits.MaxNotifications > 0 && len(user.Notifications) > limits.MaxNotifications {
+		notices = append(notices, fmt.Sprintf("Only your first %d of %d saved searches are checked.",
+			limits.MaxNotifications, len(user.Notifications)))
+		for _, notif := range user.Notifications[limits.MaxNotifications:] {
+			recordOverLimit(*user, notif)
+		}
+		user.Notifications = user.Notifications[:limits.MaxNotifications]
+	}
+
+	// Copy before trimming so the caller's notifications aren't modified
+	limited := make([]Notification, len(user.Notifications))
+	for i, notif := range user.Notifications {
+		if limits.MaxWebhooks > 0 && len(notif.Webhooks) > limits.MaxWebhooks {
+			notices = append(notices, fmt.Sprintf("%q alerts only its first %d of %d webhooks.",
+				notif.SearchTerm, limits.MaxWebhooks, len(notif.Webhooks)))
+			notif.Webhooks = notif.Webhooks[:limits.MaxWebhooks]
+		}
+
+		// Only Sendico markets are searched, so only they count
+		markets := filterSendicoMarkets(notif.Markets)
+		if len(notif.Markets) == 0 {
+			markets = slices.Sorted(maps.Keys(sendicoMarkets))
+		}
+		if limits.MaxMarkets > 0 && len(markets) > limits.MaxMarkets {
+			notices = append(notices, fmt.Sprintf("%q searches only %d of its %d markets.",
+				notif.SearchTerm, limits.MaxMarkets, len(markets)))
+			notif.Markets = markets[:limits.MaxMarkets]
+		}
+		limited[i] = notif
+	}
+	user.Notifications = limited
+	if len(notices) > 0 {
+		user.LimitNotices = notices
+	}
+}
+
+// recordOverLimit reports a saved search skipped for the tier limit in its
+// status, unless it already is
+func recordOverLimit(user User, notif Notification) {
+	if run, ok := notificationRuns.Get(user.AuthUserID, notif.ID); ok && run.ErrorCategory == runErrTierLimit {
+		return
+	}
+	notificationRuns.Record(notificationRun{
+		UserID:         user.AuthUserID,
+		NotificationID: notif.ID,
+		CheckedAt:      time.Now(),
+		ErrorCategory:  runErrTierLimit,
+		Error:          fmt.Sprintf("over the %s tier's limit of %d saved searches", tierName(user), limitsFor(user).MaxNotifications),
+	})
+}
+
+// limitNoticeInterval is how often a user is reminded of the same limits
+const limitNoticeInterval = 24 * time.Hour
+
+// limitNoticeLog remembers when each user was last told about their limits,
+// so the notice rides along with an alert once a day rather than every time
+type limitNoticeLog struct {
+	mu   sync.Mutex
+	sent map[string]limitNoticeSent
+}
+
+type limitNoticeSent struct {
+	notices string
+	at      time.Time
+}
+
+var limitNotices = &limitNoticeLog{sent: make(map[string]limitNoticeSent)}
+
+// Take returns the limits embed to attach to the user's next alert, or nil if
+// nothing was skipped or they were told recently. The notice counts as sent
+// until Forget is called.
+func (l *limitNoticeLog) Take(user User, now time.Time) *DiscordEmbed {
+	if len(user.LimitNotices) == 0 {
+		return nil
+	}
+	notices := strings.Join(user.LimitNotices, "\n")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if last, ok := l.sent[user.AuthUserID]; ok && last.notices == notices && now.Sub(last.at) < limitNoticeInterval {
+		return nil
+	}
+	l.sent[user.AuthUserID] = limitNoticeSent{notices: notices, at: now}
+	return limitsEmbed(user)
+}
+
+// Forget allows the notice to be sent again, e.g. after delivery failed
+func (l *limitNoticeLog) Forget(userID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.sent, userID)
+}
+
+// limitsEmbed tells the user what their tier caused to be skipped
+func limitsEmbed(user User) *DiscordEmbed {
+	const maxLines = 15 // Keeps the description well under Discord's 4096 characters
+	lines := make([]string, 0, maxLines+1)
+	for i, notice := range user.LimitNotices {
+		if i == maxLines {
+			lines = append(lines, fmt.Sprintf("• …and %d more.", len(user.LimitNotices)-maxLines))
+			break
+		}
+		lines = append(lines, "• "+notice)
+	}
+	return &DiscordEmbed{
+		Title:       "Some of your notification settings were skipped",
+		Description: strings.Join(lines, "\n") + "\n\nRemove saved searches, webhooks or markets to choose what is kept, or upgrade your plan.",
+		Color:       15105570, // Orange
+		Timestamp:   time.Now().Format(time.RFC3339),
+		Footer: map[string]interface{}{
+			"text": fmt.Sprintf("MMCS • %s plan limits", tierName(user)),
+		},
+	}
+}
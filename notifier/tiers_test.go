@@ -0,0 +1,186 @@
+package main
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useTiers sets the tier limits for the test
+func useTiers(t *testing.T, limits map[string]tierLimits) {
+	t.Helper()
+	previous := tiers
+	t.Cleanup(func() { tiers = previous })
+	tiers = limits
+}
+
+func TestParseTierLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[string]tierLimits
+		wantErr string
+	}{
+		{
+			name:  "empty leaves the default unlimited",
+			value: "",
+			want:  map[string]tierLimits{"": {}},
+		},
+		{
+			name:  "default and named tiers",
+			value: "default:notifications=10,webhooks=2,markets=3,poll=1m;pro:notifications=50,poll=30s",
+			want: map[string]tierLimits{
+				"":    {MaxNotifications: 10, MaxWebhooks: 2, MaxMarkets: 3, MinPollInterval: time.Minute},
+				"pro": {MaxNotifications: 50, MinPollInterval: 30 * time.Second},
+			},
+		},
+		{
+			name:  "spaces and empty entries are ignored",
+			value: " pro : webhooks = 5 , ; ; ",
+			want:  map[string]tierLimits{"": {}, "pro": {MaxWebhooks: 5}},
+		},
+		{
+			name:  "zero means no limit",
+			value: "free:notifications=0",
+			want:  map[string]tierLimits{"": {}, "free": {}},
+		},
+		{name: "missing limits", value: "pro", wantErr: "expected name:limit=value"},
+		{name: "missing name", value: ":notifications=1", wantErr: "expected name:limit=value"},
+		{name: "unknown limit", value: "pro:searches=5", wantErr: `unknown limit "searches"`},
+		{name: "not a number", value: "pro:notifications=many", wantErr: "invalid limit"},
+		{name: "negative limit", value: "pro:webhooks=-1", wantErr: "negative limit"},
+		{name: "invalid interval", value: "pro:poll=fast", wantErr: "invalid limit"},
+		{name: "negative interval", value: "pro:poll=-1m", wantErr: "negative interval"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTierLimits(tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseTierLimits(%q) error = %v, want one containing %q", tt.value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTierLimits(%q) = %+v, want %+v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyTierLimits(t *testing.T) {
+	useTiers(t, map[string]tierLimits{
+		"":    {MaxNotifications: 2, MaxWebhooks: 1, MaxMarkets: 1},
+		"pro": {},
+	})
+
+	// eBay isn't searched through Sendico, so it doesn't count toward markets
+	three := func() []Notification {
+		return []Notification{
+			{ID: "n1", SearchTerm: "miku", Markets: []string{"mercari-jp", "ebay", "rakuma"}, Webhooks: []string{"w1", "w2"}},
+			{ID: "n2", SearchTerm: "rin", Markets: []string{"rakuma"}},
+			{ID: "n3", SearchTerm: "luka"},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		tier        string
+		wantIDs     []string
+		wantMarkets [][]string
+		wantHooks   [][]string
+		wantNotices int
+	}{
+		{
+			name:        "default tier trims searches, webhooks and markets",
+			wantIDs:     []string{"n1", "n2"},
+			wantMarkets: [][]string{{"mercari-jp"}, {"rakuma"}},
+			wantHooks:   [][]string{{"w1"}, nil},
+			wantNotices: 3,
+		},
+		{
+			name:        "unknown tier gets the default",
+			tier:        "gold",
+			wantIDs:     []string{"n1", "n2"},
+			wantMarkets: [][]string{{"mercari-jp"}, {"rakuma"}},
+			wantHooks:   [][]string{{"w1"}, nil},
+			wantNotices: 3,
+		},
+		{
+			name:        "unlimited tier keeps everything",
+			tier:        "pro",
+			wantIDs:     []string{"n1", "n2", "n3"},
+			wantMarkets: [][]string{{"mercari-jp", "ebay", "rakuma"}, {"rakuma"}, nil},
+			wantHooks:   [][]string{{"w1", "w2"}, nil, nil},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notifications := three()
+			user := User{AuthUserID: "u1", Tier: tt.tier, Notifications: notifications}
+			applyTierLimits(&user)
+
+			var ids []string
+			for i, notif := range user.Notifications {
+				ids = append(ids, notif.ID)
+				if !slices.Equal(notif.Markets, tt.wantMarkets[i]) {
+					t.Errorf("%s markets = %v, want %v", notif.ID, notif.Markets, tt.wantMarkets[i])
+				}
+				if !slices.Equal(notif.Webhooks, tt.wantHooks[i]) {
+					t.Errorf("%s webhooks = %v, want %v", notif.ID, notif.Webhooks, tt.wantHooks[i])
+				}
+			}
+			if !slices.Equal(ids, tt.wantIDs) {
+				t.Errorf("notifications = %v, want %v", ids, tt.wantIDs)
+			}
+			if len(user.LimitNotices) != tt.wantNotices {
+				t.Errorf("notices = %q, want %d", user.LimitNotices, tt.wantNotices)
+			}
+			// The caller's notifications aren't modified
+			if len(notifications[0].Webhooks) != 2 || len(notifications[0].Markets) != 3 {
+				t.Errorf("original n1 changed to %+v", notifications[0])
+			}
+
+			// Applying the limits again drops nothing more and keeps the notices
+			notices := user.LimitNotices
+			applyTierLimits(&user)
+			if len(user.Notifications) != len(tt.wantIDs) || !slices.Equal(user.LimitNotices, notices) {
+				t.Errorf("second apply left %d notifications and notices %q", len(user.Notifications), user.LimitNotices)
+			}
+		})
+	}
+}
+
+func TestApplyTierLimitsCountsAllMarketsWhenNoneSelected(t *testing.T) {
+	useTiers(t, map[string]tierLimits{"": {MaxMarkets: 2}})
+
+	user := User{AuthUserID: "u1", Notifications: []Notification{{ID: "n1", SearchTerm: "miku"}}}
+	applyTierLimits(&user)
+	if got := user.Notifications[0].Markets; len(got) != 2 {
+		t.Errorf("markets = %v, want 2 of the Sendico markets", got)
+	}
+	if len(user.LimitNotices) != 1 || !strings.Contains(user.LimitNotices[0], "2 of its 5 markets") {
+		t.Errorf("notices = %q, want one for 2 of 5 markets", user.LimitNotices)
+	}
+}
+
+func TestApplyTierLimitsRecordsOverLimitStatus(t *testing.T) {
+	useTiers(t, map[string]tierLimits{"": {MaxNotifications: 1}})
+
+	user := User{AuthUserID: "tier-test-user", Notifications: []Notification{{ID: "n1"}, {ID: "n2"}}}
+	applyTierLimits(&user)
+	run, ok := notificationRuns.Get("tier-test-user", "n2")
+	if !ok || run.ErrorCategory != runErrTierLimit {
+		t.Errorf("n2 status = %+v, %v, want %s", run, ok, runErrTierLimit)
+	}
+	if _, ok := notificationRuns.Get("tier-test-user", "n1"); ok {
+		t.Error("n1 has a status, want none for a search within the limit")
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates spans for the notification pipeline:
+// cycle → user → notification → translate, cycle → search (per shop),
+// and cycle → deliver → dedupe → webhook. Until tracing is set up it is a no-op.
+var tracer = otel.Tracer("discord-notifier")
+
+// tracingEnabled reports whether an OTLP endpoint is configured
+func tracingEnabled() bool {
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// setupTracing installs a tracer provider exporting spans over OTLP/HTTP.
+// The exporter is configured through the standard OTEL_EXPORTER_OTLP_* variables.
+// The returned shutdown flushes buffered spans and stops the exporter.
+func setupTracing(ctx context.Context) (shutdown func(context.Context) error, err error) {
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", "mmcs-notifier")),
+		resource.WithFromEnv(), // OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	tracer = provider.Tracer("discord-notifier")
+	return provider.Shutdown, nil
+}
+
+// endSpan records err (if any) on span and ends it. Error messages are
+// redacted like log output, since they may contain webhook URLs or emails.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		msg := err.Error()
+		if logRedact {
+			msg = redact(msg)
+		}
+		span.RecordError(errors.New(msg))
+		span.SetStatus(codes.Error, msg)
+	}
+	span.End()
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestEndSpanRedactsError(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+
+	_, span := provider.Tracer("test").Start(context.Background(), "webhook")
+	endSpan(span, errors.New("post https://discord.com/api/webhooks/123/secret-token for alice@example.com: 404"))
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("recorded %d spans, want 1", len(spans))
+	}
+	want := "post https://discord.com/api/webhooks/123/[redacted] for a***@example.com: 404"
+	if got := spans[0].Status().Description; got != want {
+		t.Errorf("status = %q, want %q", got, want)
+	}
+	for _, event := range spans[0].Events() {
+		for _, attr := range event.Attributes {
+			if v := attr.Value.Emit(); strings.Contains(v, "secret-token") || strings.Contains(v, "alice@") {
+				t.Errorf("event %s attribute %s = %q, want it redacted", event.Name, attr.Key, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// TranslationCache is a bounded LRU cache of search term translations with a
+// per-entry TTL. It can be persisted to disk so restarts and deploys don't
+// re-translate every saved search through Sendico.
+type TranslationCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	path     string
+	order    *list.List // front = most recently used
+	entries  map[string]*list.Element
+	dirty    bool
+
+	// Deduplicates concurrent translations of the same term
+	group singleflight.Group
+
+	now func() time.Time // Replaced in tests
+}
+
+type translationCacheEntry struct {
+	Term        string    `json:"term"`
+	Translation string    `json:"translation"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// TranslateFunc performs an uncached translation
+type TranslateFunc func(ctx context.Context, text string) (string, error)
+
+// translateTimeout bounds a shared translation, which runs detached from the
+// context of the caller that started it
+const translateTimeout = 30 * time.Second
+
+func NewTranslationCache(capacity int, ttl time.Duration, path string) *TranslationCache {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	return &TranslationCache{
+		capacity: capacity,
+		ttl:      ttl,
+		path:     path,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+		now:      time.Now,
+	}
+}
+
+// Get returns the cached translation for term if present and not expired
+func (c *TranslationCache) Get(term string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[term]
+	if !ok {
+		return "", false
+	}
+	entry := elem.Value.(*translationCacheEntry)
+	if !entry.ExpiresAt.IsZero() && c.now().After(entry.ExpiresAt) {
+		c.removeElement(elem)
+		return "", false
+	}
+	c.order.MoveToFront(elem)
+	return entry.Translation, true
+}
+
+// Set stores a translation, evicting the least recently used entry when full
+func (c *TranslationCache) Set(term, translation string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var expiresAt time.Time
+	if c.ttl > 0 {
+		expiresAt = c.now().Add(c.ttl)
+	}
+	c.set(&translationCacheEntry{Term: term, Translation: translation, ExpiresAt: expiresAt})
+	c.dirty = true
+}
+
+func (c *TranslationCache) set(entry *translationCacheEntry) {
+	if elem, ok := c.entries[entry.Term]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[entry.Term] = c.order.PushFront(entry)
+	for c.order.Len() > c.capacity {
+		c.removeElement(c.order.Back())
+	}
+}
+
+func (c *TranslationCache) removeElement(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*translationCacheEntry).Term)
+	c.dirty = true
+}
+
+// Translate returns the cached translation for term, calling translate on a
+// miss. Concurrent misses for the same term share a single translate call.
+// The shared call isn't tied to ctx, so one caller giving up doesn't fail the
+// others waiting on it.
+func (c *TranslationCache) Translate(ctx context.Context, term string, translate TranslateFunc) (string, error) {
+	if translation, ok := c.Get(term); ok {
+		translationCacheRequests.WithLabelValues("hit").Inc()
+		return translation, nil
+	}
+	translationCacheRequests.WithLabelValues("miss").Inc()
+
+	shared := context.WithoutCancel(ctx)
+	ch := c.group.DoChan(term, func() (interface{}, error) {
+		// Another caller may have filled the cache while we waited
+		if translation, ok := c.Get(term); ok {
+			return translation, nil
+		}
+		ctx, cancel := context.WithTimeout(shared, translateTimeout)
+		defer cancel()
+		translation, err := translate(ctx, term)
+		if err != nil {
+			return "", err
+		}
+		c.Set(term, translation)
+		return translation, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return "", res.Err
+		}
+		return res.Val.(string), nil
+	}
+}
+
+// Len returns the number of cached entries (including expired ones not yet evicted)
+func (c *TranslationCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// Load reads persisted entries from disk. A missing file is not an error.
+func (c *TranslationCache) Load() error {
+	if c.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var entries []translationCacheEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("failed to parse translation cache %s: %w", c.path, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Entries are stored most recently used first, so insert in reverse
+	now := c.now()
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if !entry.ExpiresAt.IsZero() && now.After(entry.ExpiresAt) {
+			continue
+		}
+		c.set(&entry)
+	}
+	return nil
+}
+
+// Save writes the cache to disk if it changed since the last save
+func (c *TranslationCache) Save() error {
+	if c.path == "" {
+		return nil
+	}
+
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return nil
+	}
+	entries := make([]translationCacheEntry, 0, c.order.Len())
+	for elem := c.order.Front(); elem != nil; elem = elem.Next() {
+		entries = append(entries, *elem.Value.(*translationCacheEntry))
+	}
+	c.dirty = false
+	c.mu.Unlock()
+
+	if err := c.writeFile(entries); err != nil {
+		// Keep the cache dirty so the next save retries
+		c.mu.Lock()
+		c.dirty = true
+		c.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (c *TranslationCache) writeFile(entries []translationCacheEntry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temp file and rename so a crash never leaves a truncated cache
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
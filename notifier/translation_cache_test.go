@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced clock for code that takes a now func
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{t: time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func newTestTranslationCache(capacity int, ttl time.Duration, path string) (*TranslationCache, *fakeClock) {
+	clock := newFakeClock()
+	cache := NewTranslationCache(capacity, ttl, path)
+	cache.now = clock.Now
+	return cache, clock
+}
+
+func TestTranslationCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache, _ := newTestTranslationCache(2, 0, "")
+	cache.Set("a", "A")
+	cache.Set("b", "B")
+	cache.Get("a") // b is now the least recently used
+	cache.Set("c", "C")
+
+	if _, ok := cache.Get("b"); ok {
+		t.Error("b still cached, want it evicted")
+	}
+	for _, term := range []string{"a", "c"} {
+		if _, ok := cache.Get(term); !ok {
+			t.Errorf("%s evicted, want it cached", term)
+		}
+	}
+	if cache.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", cache.Len())
+	}
+}
+
+func TestTranslationCacheExpiresEntries(t *testing.T) {
+	cache, clock := newTestTranslationCache(10, time.Hour, "")
+	cache.Set("a", "A")
+
+	clock.Advance(59 * time.Minute)
+	if got, ok := cache.Get("a"); !ok || got != "A" {
+		t.Fatalf("Get before TTL = %q, %v, want A, true", got, ok)
+	}
+	clock.Advance(2 * time.Minute)
+	if _, ok := cache.Get("a"); ok {
+		t.Error("Get after TTL hit, want a miss")
+	}
+	if cache.Len() != 0 {
+		t.Errorf("Len() = %d, want the expired entry removed", cache.Len())
+	}
+}
+
+func TestTranslationCacheSaveLoadRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "translations.json")
+	cache, clock := newTestTranslationCache(10, time.Hour, path)
+	cache.Set("old", "OLD")
+	clock.Advance(30 * time.Minute)
+	cache.Set("a", "A")
+	cache.Set("b", "B")
+	cache.Get("a")
+	if err := cache.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The temp file is renamed into place
+	files, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != "translations.json" {
+		t.Errorf("directory holds %v, want only translations.json", files)
+	}
+
+	// "old" has expired by the time the cache is loaded again
+	clock.Advance(45 * time.Minute)
+	loaded := NewTranslationCache(2, time.Hour, path)
+	loaded.now = clock.Now
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Len() != 2 {
+		t.Fatalf("loaded %d entries, want 2", loaded.Len())
+	}
+	for term, want := range map[string]string{"a": "A", "b": "B"} {
+		if got, ok := loaded.Get(term); !ok || got != want {
+			t.Errorf("Get(%q) = %q, %v, want %q", term, got, ok, want)
+		}
+	}
+
+	// Recency survives the round trip: b was less recently used than a
+	loaded.Get("a")
+	loaded.Set("c", "C")
+	if _, ok := loaded.Get("b"); ok {
+		t.Error("b still cached after loading, want it evicted first")
+	}
+}
+
+func TestTranslationCacheLoadMissingFile(t *testing.T) {
+	cache := NewTranslationCache(10, time.Hour, filepath.Join(t.TempDir(), "missing.json"))
+	if err := cache.Load(); err != nil {
+		t.Errorf("Load() = %v, want nil for a missing file", err)
+	}
+}
+
+func TestTranslationCacheSharedTranslateOutlivesCaller(t *testing.T) {
+	cache := NewTranslationCache(10, time.Hour, "")
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var calls atomic.Int32
+	translate := func(ctx context.Context, text string) (string, error) {
+		calls.Add(1)
+		close(started)
+		select {
+		case <-release:
+			return "translated " + text, ctx.Err()
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+
+	// The first caller gives up while the translation is in flight
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := cache.Translate(ctx, "term", translate)
+		first <- err
+	}()
+	<-started
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled caller got %v, want context.Canceled", err)
+	}
+
+	// A second caller joins the same translation, which still succeeds
+	second := make(chan string, 1)
+	go func() {
+		got, err := cache.Translate(context.Background(), "term", translate)
+		if err != nil {
+			t.Error(err)
+		}
+		second <- got
+	}()
+	close(release)
+	if got := <-second; got != "translated term" {
+		t.Errorf("second caller got %q, want translated term", got)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("translate called %d times, want 1", n)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// corsAllowOrigin is sent as Access-Control-Allow-Origin on the webhook test
+// endpoint so the frontend can call it
+var corsAllowOrigin = "*"
+
+// Test messages are limited per signed-in user: a few at once, then one every
+// webhookTestInterval
+const (
+	webhookTestBurst    = 3
+	webhookTestInterval = 10 * time.Second
+)
+
+var webhookTestLimiter = newKeyedLimiter(1/webhookTestInterval.Seconds(), webhookTestBurst)
+
+// keyedLimiter keeps a token bucket per key, e.g. per user
+type keyedLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   int
+	buckets map[string]*tokenBucket
+}
+
+func newKeyedLimiter(rate float64, burst int) *keyedLimiter {
+	return &keyedLimiter{rate: rate, burst: burst, buckets: make(map[string]*tokenBucket)}
+}
+
+// Allow takes a token from key's bucket, reporting false if it has none
+func (l *keyedLimiter) Allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		// Forget keys whose buckets have refilled, which act like new ones
+		if len(l.buckets) >= 1000 {
+			for k, b := range l.buckets {
+				if b.refill(now); b.tokens >= b.burst {
+					delete(l.buckets, k)
+				}
+			}
+		}
+		bucket = newTokenBucket(l.rate, l.burst, now)
+		l.buckets[key] = bucket
+	}
+	if bucket.delay(now) > 0 {
+		return false
+	}
+	bucket.take()
+	return true
+}
+
+// webhookTestResult reports how Discord responded to a test message
+type webhookTestResult struct {
+	OK         bool   `json:"ok"`
+	StatusCode int    `json:"status_code,omitempty"` // Discord's HTTP status, when it responded
+	Error      string `json:"error,omitempty"`
+}
+
+// sendTestWebhook sends a sample listing to webhookURL, rendered through the
+// same embed path as real notifications
+func sendTestWebhook(ctx context.Context, webhookURL string) webhookTestResult {
+	if !isDiscordWebhookURL(webhookURL) {
+		return webhookTestResult{Error: "invalid webhook URL: expected https://discord.com/api/webhooks/..."}
+	}
+
+	notif := Notification{ID: "test", SearchTerm: "Hatsune Miku figure"}
+	sample := SendicoItem{
+		Shop:     SendicoMercari,
+		Name:     "Test listing: Hatsune Miku figure",
+		URL:      "https://jp.mercari.com/",
+		PriceYen: 5000,
+		PriceUSD: 33,
+		Labels:   []string{"used", "free_shipping"},
+	}
+	payload := buildDiscordPayloads(notif, newAlertItems([]SendicoItem{sample}), defaultCurrency)[0]
+	payload.Content = "✅ **MMCS test message** — this webhook is set up correctly. Notifications will look like this:"
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return webhookTestResult{Error: fmt.Sprintf("failed to marshal payload: %v", err)}
+	}
+
+	err = postDiscordWebhook(ctx, webhookURL, jsonData, len(payload.Embeds))
+	if err == nil {
+		return webhookTestResult{OK: true, StatusCode: http.StatusNoContent}
+	}
+	result := webhookTestResult{Error: err.Error()}
+	var statusErr *discordStatusError
+	if errors.As(err, &statusErr) {
+		result.StatusCode = statusErr.StatusCode
+	}
+	return result
+}
+
+// runTestWebhook implements the "test-webhook" subcommand:
+//
+//	notifier test-webhook https://discord.com/api/webhooks/...
+func runTestWebhook(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: notifier test-webhook <webhook-url>")
+		os.Exit(2)
+	}
+
+	result := sendTestWebhook(context.Background(), strings.TrimSpace(args[0]))
+	if !result.OK {
+		fatal("test message failed", "status", result.StatusCode, "error", result.Error)
+	}
+	fmt.Println("Test message sent")
+}
+
+// handleTestWebhook sends a test message to the webhook in the request body,
+// {"webhook_url": "..."}, and returns Discord's response. Callers must send
+// their Supabase access token as a bearer token.
+func handleTestWebhook(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w)
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		writeJSONError(w, http.StatusUnauthorized, "sign in to test webhooks")
+		return
+	}
+	userID, err := supabaseUserID(r.Context(), token)
+	if err != nil {
+		slog.Debug("rejected webhook test", "error", err)
+		writeJSONError(w, http.StatusUnauthorized, "sign in to test webhooks")
+		return
+	}
+
+	var req struct {
+		WebhookURL string `json:"webhook_url"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	webhookURL := strings.TrimSpace(req.WebhookURL)
+	if !isDiscordWebhookURL(webhookURL) {
+		writeJSON(w, http.StatusBadRequest, webhookTestResult{Error: "invalid webhook URL: expected https://discord.com/api/webhooks/..."})
+		return
+	}
+
+	if !webhookTestLimiter.Allow(userID, time.Now()) {
+		writeJSONError(w, http.StatusTooManyRequests, "too many test messages, try again shortly")
+		return
+	}
+
+	result := sendTestWebhook(r.Context(), webhookURL)
+	slog.Info("sent test webhook message", "user_id", userID, "webhook", webhookURL, "ok", result.OK, "status", result.StatusCode)
+
+	// The request itself succeeded; whether Discord accepted the message is in the body
+	writeJSON(w, http.StatusOK, result)
+}
+
+// supabaseUserID returns the ID of the user a Supabase access token belongs
+// to, asking Supabase Auth to validate it
+func supabaseUserID(ctx context.Context, token string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, supabaseURL+"/auth/v1/user", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("apikey", supabaseKey)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("supabase auth returned status %d", resp.StatusCode)
+	}
+	var user struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return "", err
+	}
+	if user.ID == "" {
+		return "", errors.New("supabase auth returned no user")
+	}
+	return user.ID, nil
+}
+
+func handleTestWebhookPreflight(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func setCORSHeaders(w http.ResponseWriter) {
+	w.Header().Set("Access-Control-Allow-Origin", corsAllowOrigin)
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+	w.Header().Set("Access-Control-Max-Age", "86400")
+}
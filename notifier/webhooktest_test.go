@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFakeSupabaseAuth points supabaseURL at a server that accepts token
+// "good" as user u1
+func newFakeSupabaseAuth(t *testing.T) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/auth/v1/user" || r.Header.Get("Authorization") != "Bearer good" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"id": "u1"}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	previous := supabaseURL
+	supabaseURL = srv.URL
+	t.Cleanup(func() { supabaseURL = previous })
+}
+
+func TestHandleTestWebhookRequiresSignIn(t *testing.T) {
+	newFakeSupabaseAuth(t)
+
+	tests := []struct {
+		name          string
+		authorization string
+		body          string
+		wantStatus    int
+	}{
+		{name: "no token", body: `{"webhook_url": "https://discord.com/api/webhooks/1/abc"}`, wantStatus: http.StatusUnauthorized},
+		{name: "rejected token", authorization: "Bearer bad", body: `{"webhook_url": "https://discord.com/api/webhooks/1/abc"}`, wantStatus: http.StatusUnauthorized},
+		{name: "signed in with an invalid webhook", authorization: "Bearer good", body: `{"webhook_url": "https://example.com/hook"}`, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/webhooks/test", strings.NewReader(tt.body))
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handleTestWebhook(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestKeyedLimiterIsPerKey(t *testing.T) {
+	clock := newFakeClock()
+	limiter := newKeyedLimiter(0.1, 2)
+
+	for i := 0; i < 2; i++ {
+		if !limiter.Allow("u1", clock.Now()) {
+			t.Fatalf("u1 request %d denied within the burst", i+1)
+		}
+	}
+	if limiter.Allow("u1", clock.Now()) {
+		t.Error("u1 allowed past the burst")
+	}
+	if !limiter.Allow("u2", clock.Now()) {
+		t.Error("u2 denied because of u1's requests")
+	}
+
+	clock.Advance(10 * time.Second)
+	if !limiter.Allow("u1", clock.Now()) {
+		t.Error("u1 denied after its bucket refilled")
+	}
+}
@@ -0,0 +1,37 @@
+-- Saved Discord notifications, one row per saved search.
+-- Replaces the unlocked_users.discord_notifications jsonb blob, which is kept
+-- for now so the notifier can fall back to it. Populate this table from the
+-- blobs with: cd notifier && go run . migrate-notifications
+
+create table if not exists public.user_notifications (
+  auth_user_id text not null,
+  id text not null,
+  position integer not null default 0,
+  -- Required: migrate-notifications turns a blob entry with only aliases
+  -- into one searching its first alias
+  search_term text not null check (length(trim(search_term)) > 0),
+  markets text[] not null default '{}',
+  webhooks text[] not null default '{}',
+  aliases text[] not null default '{}',
+  min_price integer check (min_price is null or min_price >= 0),
+  max_price integer check (max_price is null or max_price >= 0),
+  created_at timestamptz not null default now(),
+  primary key (auth_user_id, id)
+);
+
+create index if not exists user_notifications_auth_user_id_idx
+  on public.user_notifications (auth_user_id, position);
+
+-- Users manage their own notifications; the notifier uses the service role
+alter table public.user_notifications enable row level security;
+
+drop policy if exists "Users manage their own notifications" on public.user_notifications;
+create policy "Users manage their own notifications"
+  on public.user_notifications
+  for all
+  using (auth.uid()::text = auth_user_id)
+  with check (auth.uid()::text = auth_user_id);
+
+-- Deletes must carry the full row so realtime subscribers know whose
+-- notification was removed
+alter table public.user_notifications replica identity full;
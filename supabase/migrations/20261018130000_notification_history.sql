@@ -0,0 +1,33 @@
+-- Every item delivered to Discord, written by the notifier after a webhook
+-- accepts it. Backs the alert feed in the frontend and support questions.
+
+create table if not exists public.notification_history (
+  id bigint generated always as identity primary key,
+  auth_user_id text not null,
+  notification_id text not null,
+  shop text not null,
+  code text not null,
+  title text not null,
+  price_yen integer,
+  price_usd integer,
+  url text,
+  image text,
+  -- Discord webhook ID only; the token is a secret and isn't stored
+  webhook_id text,
+  delivered_at timestamptz not null default now()
+);
+
+create index if not exists notification_history_user_idx
+  on public.notification_history (auth_user_id, delivered_at desc);
+
+create index if not exists notification_history_notification_idx
+  on public.notification_history (auth_user_id, notification_id, delivered_at desc);
+
+-- Users can read their own history; only the notifier (service role) writes
+alter table public.notification_history enable row level security;
+
+drop policy if exists "Users read their own notification history" on public.notification_history;
+create policy "Users read their own notification history"
+  on public.notification_history
+  for select
+  using (auth.uid()::text = auth_user_id);
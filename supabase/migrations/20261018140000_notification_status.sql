@@ -0,0 +1,30 @@
+-- Outcome of the last check of every saved search, written by the notifier
+-- after each cycle so the frontend can show why a search isn't alerting.
+
+create table if not exists public.notification_status (
+  auth_user_id text not null,
+  notification_id text not null,
+  checked_at timestamptz not null,
+  -- Empty when the check succeeded; otherwise one of no_supported_markets,
+  -- no_sendico_markets, no_search_terms, translation_failed, no_webhooks,
+  -- webhook_failed, over_tier_limit, invalid_template, invalid_mentions
+  error_category text not null default '',
+  error text not null default '',
+  translated_term text not null default '',
+  terms text[] not null default '{}',
+  items_found integer not null default 0,
+  new_items integer not null default 0,
+  webhooks_sent integer not null default 0,
+  webhooks_failed integer not null default 0,
+  webhooks_invalid integer not null default 0,
+  primary key (auth_user_id, notification_id)
+);
+
+-- Users can read the status of their own searches; only the notifier writes
+alter table public.notification_status enable row level security;
+
+drop policy if exists "Users read their own notification status" on public.notification_status;
+create policy "Users read their own notification status"
+  on public.notification_status
+  for select
+  using (auth.uid()::text = auth_user_id);
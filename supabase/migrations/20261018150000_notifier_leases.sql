@@ -0,0 +1,12 @@
+-- Leases held by notifier instances: "shard-0-of-3" while an instance checks
+-- that shard of subscribers, or "leader" with LEADER_ELECTION. A lease is free once expires_at passes;
+-- the primary key stops two instances taking it at once.
+
+create table if not exists public.notifier_leases (
+  name text primary key,
+  holder text not null,
+  expires_at timestamptz not null
+);
+
+-- Only the notifier (service role) reads or writes leases
+alter table public.notifier_leases enable row level security;
@@ -0,0 +1,5 @@
+-- Subscription tier selecting the limits the notifier applies (TIER_LIMITS).
+-- Null, or a tier the notifier has no limits for, means the default tier.
+
+alter table public.unlocked_users
+  add column if not exists notifications_tier text;
@@ -0,0 +1,16 @@
+-- Subscription expiry warnings the notifier has sent to a user's webhook:
+-- "<n>d" reminders before expiry, "lapsed" when the subscription expires,
+-- "ended" when the grace period is over and "inactive" when the subscription
+-- is switched off early. Keyed by the expiry, so renewing starts a fresh set
+-- of warnings.
+
+create table if not exists public.subscription_warnings (
+  auth_user_id text not null,
+  expires_at timestamptz not null,
+  kind text not null,
+  sent_at timestamptz not null default now(),
+  primary key (auth_user_id, expires_at, kind)
+);
+
+-- Only the notifier (service role) reads or writes warnings
+alter table public.subscription_warnings enable row level security;
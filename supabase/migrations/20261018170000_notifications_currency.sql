@@ -0,0 +1,5 @@
+-- Currency a user wants alert prices shown in, next to yen: JPY, USD, or a
+-- currency the notifier has a rate for (CURRENCY_RATES). Null means USD.
+
+alter table public.unlocked_users
+  add column if not exists notifications_currency text;
@@ -0,0 +1,7 @@
+-- Custom message format of a saved search: a style ("embed" or "compact")
+-- and Go text/template sources for the message text and embed parts. Null
+-- uses the notifier's default format. Mirrors the "template" key of the
+-- notification objects in unlocked_users.discord_notifications.
+
+alter table public.user_notifications
+  add column if not exists template jsonb;
@@ -0,0 +1,8 @@
+-- Discord roles and users a saved search pings for priority listings, and
+-- the rules that make a listing one: {"roles": [...], "users": [...],
+-- "maxPrice": yen, "keywords": [...]}. Null pings nobody. Mirrors the
+-- "mentions" key of the notification objects in
+-- unlocked_users.discord_notifications.
+
+alter table public.user_notifications
+  add column if not exists mentions jsonb;